@@ -0,0 +1,138 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// CloudFormationSchema holds what a CloudFormation resource provider schema says about diffing a resource, for
+// computing the RFC 6902 patches AWS Cloud Control `UpdateResource` takes.
+type CloudFormationSchema struct {
+	TypeName    string
+	Collections Collections
+	// CreateOnlyPaths are the properties that can only be set when the resource is created, changing them requires
+	// replacing the resource. They are also the ImmutableFields of the Collections.
+	CreateOnlyPaths []Path
+	// WriteOnlyPaths are the properties that are never returned when the resource is read.
+	WriteOnlyPaths []Path
+	// PrimaryIdentifier are the properties that identify the resource.
+	PrimaryIdentifier []Path
+}
+
+// cloudFormationSchema is the part of a resource provider schema that is not plain Json Schema.
+type cloudFormationSchema struct {
+	TypeName             string   `json:"typeName"`
+	PrimaryIdentifier    []string `json:"primaryIdentifier"`
+	ReadOnlyProperties   []string `json:"readOnlyProperties"`
+	WriteOnlyProperties  []string `json:"writeOnlyProperties"`
+	CreateOnlyProperties []string `json:"createOnlyProperties"`
+}
+
+// LoadCloudFormationSchema reads the resource provider schema at `file`, see ParseCloudFormationSchema.
+func LoadCloudFormationSchema(file string) (*CloudFormationSchema, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := ParseCloudFormationSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return schema, nil
+}
+
+// ParseCloudFormationSchema derives Collections from a CloudFormation resource provider schema.
+//
+// Arrays with `insertionOrder: false` become sets, all other arrays keep their order and become one of the Arrays,
+// which is how CloudFormation itself compares them. Arrays marked with IdentityKeyword become entity sets.
+// `readOnlyProperties` become IgnoredFields and `createOnlyProperties` become ImmutableFields. `createOnlyProperties`,
+// `writeOnlyProperties` and `primaryIdentifier` are also returned as paths of their own.
+func ParseCloudFormationSchema(data []byte) (*CloudFormationSchema, error) {
+	var root any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, errBadJsonDoc
+	}
+	var cfn cloudFormationSchema
+	if err := json.Unmarshal(data, &cfn); err != nil {
+		return nil, fmt.Errorf("invalid resource provider schema: %w", err)
+	}
+
+	w := &schemaWalker{root: root, classify: classifyCloudFormationArray, entitySets: EntitySets{}}
+	if err := w.walk(root, "$"); err != nil {
+		return nil, err
+	}
+	readOnly, err := cloudFormationPaths(cfn.ReadOnlyProperties)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range readOnly {
+		// Ignoring every element of an array means ignoring the array.
+		w.ignoredFields = append(w.ignoredFields, Path(strings.TrimSuffix(string(path), "[*]")))
+	}
+	collections, err := w.collections()
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &CloudFormationSchema{TypeName: cfn.TypeName, Collections: collections}
+	if schema.CreateOnlyPaths, err = cloudFormationPaths(cfn.CreateOnlyProperties); err != nil {
+		return nil, err
+	}
+	schema.Collections.ImmutableFields = slices.Clone(schema.CreateOnlyPaths)
+	if schema.WriteOnlyPaths, err = cloudFormationPaths(cfn.WriteOnlyProperties); err != nil {
+		return nil, err
+	}
+	if schema.PrimaryIdentifier, err = cloudFormationPaths(cfn.PrimaryIdentifier); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// classifyCloudFormationArray classifies an array of a resource provider schema.
+func classifyCloudFormationArray(w *schemaWalker, array map[string]any, items any) (collectionKind, Key, error) {
+	return w.classifyByIdentity(array, items, withoutInsertionOrder)
+}
+
+// withoutInsertionOrder reports whether an array schema describes a set, for resource provider schemas.
+func withoutInsertionOrder(array map[string]any) bool {
+	insertionOrder, ok := array["insertionOrder"].(bool)
+	return ok && !insertionOrder
+}
+
+// cloudFormationPaths converts property pointers like `/properties/Tags/*/Key` to Json paths like `$.Tags[*].Key`.
+func cloudFormationPaths(pointers []string) ([]Path, error) {
+	paths := make([]Path, 0, len(pointers))
+	for _, pointer := range pointers {
+		path, err := cloudFormationPath(pointer)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func cloudFormationPath(pointer string) (Path, error) {
+	properties, ok := strings.CutPrefix(pointer, "/properties/")
+	if !ok || properties == "" {
+		return "", fmt.Errorf("invalid property pointer %q: must start with /properties/", pointer)
+	}
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, token := range strings.Split(properties, "/") {
+		token = rfc6901Decoder.Replace(token)
+		switch {
+		case token == "*":
+			sb.WriteString("[*]")
+		case token == "" || strings.ContainsAny(token, ".["):
+			return "", fmt.Errorf("invalid property pointer %q: %q cannot be expressed in a Json path", pointer, token)
+		default:
+			sb.WriteString(".")
+			sb.WriteString(token)
+		}
+	}
+	return Path(sb.String()), nil
+}
@@ -0,0 +1,322 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// CompiledCollections is the compiled, immutable form of Collections. It answers whether a path is an array or an
+// entity set with a walk over a trie of path segments instead of rebuilding and comparing Json paths for every node.
+//
+// A CompiledCollections is safe for concurrent use and can be reused across any number of CreatePatch calls.
+type CompiledCollections struct {
+	collections Collections
+	root        *pathNode
+}
+
+// pathNode is a node in the trie of Json path segments, `$.a[*].b` is stored as root -> "a" -> [*] -> "b".
+type pathNode struct {
+	members   map[string]*pathNode
+	element   *pathNode
+	array     bool
+	entitySet bool
+	key       Key
+	immutable bool
+	atomic    bool
+	// included is set for the nodes of IncludedFields, includes for them and all the nodes above them.
+	included, includes bool
+	ignoreRules        []IgnoreRule
+	equivalence        Equivalence
+	defaultValue       any
+	hasDefault         bool
+}
+
+// Compile validates the collections and compiles them for use with CompiledCollections.CreatePatch.
+//
+// An error is returned if any of the paths is not a valid Json path of the form `$.a[*].b`, if an entity set has no
+// key or if a path is declared as both an array and an entity set.
+func (c Collections) Compile() (*CompiledCollections, error) {
+	compiled := &CompiledCollections{
+		collections: Collections{
+			EntitySets:      maps.Clone(c.EntitySets),
+			Arrays:          slices.Clone(c.Arrays),
+			IgnoredFields:   slices.Clone(c.IgnoredFields),
+			IncludedFields:  slices.Clone(c.IncludedFields),
+			ImmutableFields: slices.Clone(c.ImmutableFields),
+			AtomicFields:    slices.Clone(c.AtomicFields),
+			IgnoreRules:     slices.Clone(c.IgnoreRules),
+			Equivalences:    maps.Clone(c.Equivalences),
+			Defaults:        maps.Clone(c.Defaults),
+		},
+		root: &pathNode{},
+	}
+
+	for _, path := range c.Arrays {
+		segments, err := parseJsonPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := c.EntitySets[path]; ok {
+			return nil, fmt.Errorf("path %q is declared as both an array and an entity set", path)
+		}
+		compiled.root.insert(segments).array = true
+	}
+	// Compile the entity sets in a fixed order, so the same invalid collections always report the same error.
+	for _, path := range slices.Sorted(maps.Keys(c.EntitySets)) {
+		key := c.EntitySets[path]
+		segments, err := parseJsonPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("entity set %q has no key", path)
+		}
+		if slices.Contains(key.Fields(), "") {
+			return nil, fmt.Errorf("entity set %q has an empty field in its key %q", path, key)
+		}
+		node := compiled.root.insert(segments)
+		node.entitySet = true
+		node.key = key
+	}
+	for _, path := range c.IgnoredFields {
+		if err := validateIgnoredField(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range c.IncludedFields {
+		segments, err := parseJsonPath(path)
+		if err != nil {
+			return nil, err
+		}
+		compiled.root.include(segments)
+	}
+	for _, path := range c.ImmutableFields {
+		segments, err := parseJsonPath(path)
+		if err != nil {
+			return nil, err
+		}
+		compiled.root.insert(segments).immutable = true
+	}
+	for _, path := range c.AtomicFields {
+		segments, err := parseJsonPath(path)
+		if err != nil {
+			return nil, err
+		}
+		node := compiled.root.insert(segments)
+		if node.array || node.entitySet {
+			return nil, fmt.Errorf("path %q is declared as atomic and as an array or entity set", path)
+		}
+		node.atomic = true
+	}
+	for _, rule := range c.IgnoreRules {
+		if err := compiled.root.addIgnoreRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(c.Equivalences)) {
+		equivalence := c.Equivalences[path]
+		segments, err := parseJsonPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if equivalence <= 0 || equivalence&^(NullIsMissing|EmptyIsMissing|DefaultIsMissing) != 0 {
+			return nil, fmt.Errorf("invalid equivalence %d for path %q", equivalence, path)
+		}
+		compiled.root.insert(segments).equivalence |= equivalence
+	}
+	for _, path := range slices.Sorted(maps.Keys(c.Defaults)) {
+		segments, err := parseJsonPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) == 0 || segments[len(segments)-1] == "[*]" {
+			return nil, fmt.Errorf("invalid default %q: only properties have defaults", path)
+		}
+		value, err := decodedJson(c.Defaults[path])
+		if err != nil {
+			return nil, fmt.Errorf("invalid default for %q: %w", path, err)
+		}
+		node := compiled.root.insert(segments)
+		node.defaultValue, node.hasDefault = value, true
+	}
+
+	return compiled, nil
+}
+
+// validateIgnoredField checks that `path` is a Json path removeIgnoredFields can remove.
+func validateIgnoredField(path Path) error {
+	segments, err := parseJsonPath(path)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("invalid ignored field %q: cannot ignore the whole document", path)
+	}
+	for i, segment := range segments {
+		if segment == "[*]" && (i == len(segments)-1 || segments[i+1] == "[*]") {
+			return fmt.Errorf("invalid ignored field %q: every [*] must be followed by a property", path)
+		}
+	}
+	return nil
+}
+
+// Collections returns a copy of the collections this was compiled from.
+func (c *CompiledCollections) Collections() Collections {
+	return Collections{
+		EntitySets:      maps.Clone(c.collections.EntitySets),
+		Arrays:          slices.Clone(c.collections.Arrays),
+		IgnoredFields:   slices.Clone(c.collections.IgnoredFields),
+		IncludedFields:  slices.Clone(c.collections.IncludedFields),
+		ImmutableFields: slices.Clone(c.collections.ImmutableFields),
+		AtomicFields:    slices.Clone(c.collections.AtomicFields),
+		IgnoreRules:     slices.Clone(c.collections.IgnoreRules),
+		Equivalences:    maps.Clone(c.collections.Equivalences),
+		Defaults:        maps.Clone(c.collections.Defaults),
+	}
+}
+
+func (c *CompiledCollections) isArray(path string) bool {
+	return c.lookup(path).isArray()
+}
+
+func (c *CompiledCollections) isEntitySet(path string) bool {
+	return c.lookup(path).isEntitySet()
+}
+
+func (c *CompiledCollections) isAtomic(path string) bool {
+	node := c.lookup(path)
+	return node != nil && node.atomic
+}
+
+// entitySetKey returns the key of the entity set at `path`.
+func (c *CompiledCollections) entitySetKey(path string) (Key, bool) {
+	node := c.lookup(path)
+	if !node.isEntitySet() {
+		return "", false
+	}
+	return node.key, true
+}
+
+// isImmutable returns true if the Json pointer `path` is one of the ImmutableFields or is below one of them.
+func (c *CompiledCollections) isImmutable(path string) bool {
+	node := c.root
+	for len(path) > 0 && node != nil {
+		if node.immutable {
+			return true
+		}
+		if path[0] == '/' {
+			path = path[1:]
+			continue
+		}
+		segment, rest, _ := strings.Cut(path, "/")
+		path = rest
+		node = node.member(rfc6901Decoder.Replace(segment))
+	}
+	return node != nil && node.immutable
+}
+
+// lookup returns the trie node for a Json pointer such as `/a/0/b`, or nil if no collection is declared at or below it.
+// Array indexes match `[*]`.
+func (c *CompiledCollections) lookup(path string) *pathNode {
+	node := c.root
+	for len(path) > 0 && node != nil {
+		if path[0] == '/' {
+			path = path[1:]
+			continue
+		}
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		segment := path[:end]
+		path = path[end:]
+		if strings.IndexByte(segment, '~') >= 0 {
+			segment = rfc6901Decoder.Replace(segment)
+		}
+		node = node.member(segment)
+	}
+	return node
+}
+
+func (n *pathNode) insert(segments []string) *pathNode {
+	node := n
+	for _, segment := range segments {
+		if segment == "[*]" {
+			if node.element == nil {
+				node.element = &pathNode{}
+			}
+			node = node.element
+			continue
+		}
+		if node.members == nil {
+			node.members = make(map[string]*pathNode)
+		}
+		child, ok := node.members[segment]
+		if !ok {
+			child = &pathNode{}
+			node.members[segment] = child
+		}
+		node = child
+	}
+	return node
+}
+
+// member returns the node for the object member or array index `key`. Json pointers do not tell the two apart, so
+// numeric keys are always treated as array indexes.
+func (n *pathNode) member(key string) *pathNode {
+	if n == nil {
+		return nil
+	}
+	if isIndex(key) {
+		return n.element
+	}
+	return n.members[key]
+}
+
+// elements returns the node for the elements of the array at n.
+func (n *pathNode) elements() *pathNode {
+	if n == nil {
+		return nil
+	}
+	return n.element
+}
+
+func (n *pathNode) isArray() bool {
+	return n != nil && n.array
+}
+
+func (n *pathNode) isEntitySet() bool {
+	return n != nil && n.entitySet
+}
+
+// parseJsonPath splits a Json path such as `$.a[*].b` into its segments, `[*]` is kept as a segment of its own.
+func parseJsonPath(path Path) ([]string, error) {
+	s := string(path)
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("invalid path %q: must start with $", path)
+	}
+	s = s[1:]
+	var segments []string
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "[*]"):
+			segments = append(segments, "[*]")
+			s = s[3:]
+		case s[0] == '.':
+			end := strings.IndexAny(s[1:], ".[")
+			if end < 0 {
+				end = len(s) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty property name", path)
+			}
+			segments = append(segments, s[1:end+1])
+			s = s[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid path %q: expected .property or [*] at %q", path, s)
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,410 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigVersion is the version of the config format read by ParseConfig and written by Config.Marshal.
+const ConfigVersion = 1
+
+// Config holds the diff rules for a number of resource types, so they can be shipped as data. A config file is YAML
+// (or Json) of the form:
+//
+//	version: 1
+//	resources:
+//	  AWS::S3::Bucket:
+//	    strategy: exact-match
+//	    entitySets:
+//	      $.Tags: Key
+//	    arrays:
+//	      - $.LifecycleConfiguration.Rules
+//	    ignoredFields:
+//	      - $.Arn
+//	    immutableFields:
+//	      - $.BucketName
+//	    atomicFields:
+//	      - $.CorsConfiguration
+//	    equivalences:
+//	      $: [null, empty]
+//	    defaults:
+//	      $.VersioningConfiguration.Status: Suspended
+//	    ignoreRules:
+//	      - path: $.Tags[*]
+//	        field: Key
+//	        ifPrefix: "aws:"
+//
+// The strategy defaults to exact-match when it is omitted.
+type Config struct {
+	Version   int
+	Resources map[string]ResourceConfig
+}
+
+// ResourceConfig holds the diff rules for a single resource type.
+type ResourceConfig struct {
+	Strategy    PatchStrategy
+	Collections Collections
+}
+
+// ConfigError is an error in a config file. Line and Column are 1-based, File is only set by LoadConfig.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ConfigError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Resource returns the rules for the resource type `name`.
+func (c *Config) Resource(name string) (ResourceConfig, bool) {
+	resource, ok := c.Resources[name]
+	return resource, ok
+}
+
+// LoadConfig reads and validates the config file at `file`.
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config, err := ParseConfig(data)
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		configErr.File = file
+	}
+	return config, err
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// ParseConfig parses and validates a config. Errors are returned as *ConfigError.
+func ParseConfig(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		line := 0
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		return nil, &ConfigError{Line: line, Column: 1, Msg: err.Error()}
+	}
+	if len(root.Content) == 0 {
+		return nil, &ConfigError{Line: 1, Column: 1, Msg: "empty config"}
+	}
+
+	doc := root.Content[0]
+	if err := expectKind(doc, yaml.MappingNode, "config"); err != nil {
+		return nil, err
+	}
+	config := &Config{Resources: map[string]ResourceConfig{}}
+	var version *yaml.Node
+	err := forEachMember(doc, func(key, value *yaml.Node) error {
+		switch key.Value {
+		case "version":
+			version = value
+			v, err := strconv.Atoi(value.Value)
+			if value.Kind != yaml.ScalarNode || err != nil {
+				return configError(value, "version must be an integer")
+			}
+			if v != ConfigVersion {
+				return configError(value, fmt.Sprintf("unsupported version %d, expected %d", v, ConfigVersion))
+			}
+			config.Version = v
+		case "resources":
+			if err := expectKind(value, yaml.MappingNode, "resources"); err != nil {
+				return err
+			}
+			return forEachMember(value, func(name, resource *yaml.Node) error {
+				r, err := parseResourceConfig(resource)
+				if err != nil {
+					return err
+				}
+				config.Resources[name.Value] = r
+				return nil
+			})
+		default:
+			return configError(key, fmt.Sprintf("unknown field %q", key.Value))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, configError(doc, "missing version")
+	}
+	return config, nil
+}
+
+func parseResourceConfig(node *yaml.Node) (ResourceConfig, error) {
+	resource := ResourceConfig{
+		Strategy:    PatchStrategyExactMatch,
+		Collections: Collections{EntitySets: EntitySets{}, Arrays: []Path{}, IgnoredFields: []Path{}, IncludedFields: []Path{}, ImmutableFields: []Path{}, AtomicFields: []Path{}, Equivalences: map[Path]Equivalence{}, Defaults: map[Path]any{}},
+	}
+	if err := expectKind(node, yaml.MappingNode, "resource"); err != nil {
+		return resource, err
+	}
+	entitySets := make(map[Path]*yaml.Node)
+	var arrays []*yaml.Node
+
+	err := forEachMember(node, func(key, value *yaml.Node) error {
+		switch key.Value {
+		case "strategy":
+			switch strategy := PatchStrategy(value.Value); strategy {
+			case PatchStrategyExactMatch, PatchStrategyEnsureExists, PatchStrategyEnsureAbsent:
+				resource.Strategy = strategy
+			default:
+				return configError(value, fmt.Sprintf("unknown strategy %q", value.Value))
+			}
+		case "entitySets":
+			if err := expectKind(value, yaml.MappingNode, "entitySets"); err != nil {
+				return err
+			}
+			return forEachMember(value, func(path, key *yaml.Node) error {
+				if _, err := parseJsonPath(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				if key.Kind != yaml.ScalarNode || key.Value == "" {
+					return configError(key, fmt.Sprintf("entity set %q must have a key", path.Value))
+				}
+				resource.Collections.EntitySets[Path(path.Value)] = Key(key.Value)
+				entitySets[Path(path.Value)] = path
+				return nil
+			})
+		case "arrays":
+			return forEachPath(value, "arrays", func(path *yaml.Node) error {
+				if _, err := parseJsonPath(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				resource.Collections.Arrays = append(resource.Collections.Arrays, Path(path.Value))
+				arrays = append(arrays, path)
+				return nil
+			})
+		case "ignoredFields":
+			return forEachPath(value, "ignoredFields", func(path *yaml.Node) error {
+				if err := validateIgnoredField(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				resource.Collections.IgnoredFields = append(resource.Collections.IgnoredFields, Path(path.Value))
+				return nil
+			})
+		case "includedFields":
+			return forEachPath(value, "includedFields", func(path *yaml.Node) error {
+				if _, err := parseJsonPath(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				resource.Collections.IncludedFields = append(resource.Collections.IncludedFields, Path(path.Value))
+				return nil
+			})
+		case "immutableFields":
+			return forEachPath(value, "immutableFields", func(path *yaml.Node) error {
+				if _, err := parseJsonPath(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				resource.Collections.ImmutableFields = append(resource.Collections.ImmutableFields, Path(path.Value))
+				return nil
+			})
+		case "atomicFields":
+			return forEachPath(value, "atomicFields", func(path *yaml.Node) error {
+				if _, err := parseJsonPath(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				resource.Collections.AtomicFields = append(resource.Collections.AtomicFields, Path(path.Value))
+				return nil
+			})
+		case "equivalences":
+			if err := expectKind(value, yaml.MappingNode, "equivalences"); err != nil {
+				return err
+			}
+			return forEachMember(value, func(path, names *yaml.Node) error {
+				if _, err := parseJsonPath(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				return forEachPath(names, "equivalences", func(name *yaml.Node) error {
+					equivalence, err := ParseEquivalence(name.Value)
+					if err != nil {
+						return configError(name, err.Error())
+					}
+					resource.Collections.Equivalences[Path(path.Value)] |= equivalence
+					return nil
+				})
+			})
+		case "defaults":
+			if err := expectKind(value, yaml.MappingNode, "defaults"); err != nil {
+				return err
+			}
+			return forEachMember(value, func(path, defaultValue *yaml.Node) error {
+				if _, err := parseJsonPath(Path(path.Value)); err != nil {
+					return configError(path, err.Error())
+				}
+				var v any
+				if err := defaultValue.Decode(&v); err != nil {
+					return configError(defaultValue, err.Error())
+				}
+				resource.Collections.Defaults[Path(path.Value)] = v
+				return nil
+			})
+		case "ignoreRules":
+			if err := expectKind(value, yaml.SequenceNode, "ignoreRules"); err != nil {
+				return err
+			}
+			for _, rule := range value.Content {
+				r, err := parseIgnoreRule(rule)
+				if err != nil {
+					return err
+				}
+				resource.Collections.IgnoreRules = append(resource.Collections.IgnoreRules, r)
+			}
+		default:
+			return configError(key, fmt.Sprintf("unknown field %q", key.Value))
+		}
+		return nil
+	})
+	if err != nil {
+		return resource, err
+	}
+
+	for _, path := range arrays {
+		if _, ok := entitySets[Path(path.Value)]; ok {
+			return resource, configError(path, fmt.Sprintf("path %q is declared as both an array and an entity set", path.Value))
+		}
+	}
+	// Anything the checks above missed is still caught here, reported at the resource itself.
+	if _, err := resource.Collections.Compile(); err != nil {
+		return resource, configError(node, err.Error())
+	}
+	return resource, nil
+}
+
+func parseIgnoreRule(node *yaml.Node) (IgnoreRule, error) {
+	var rule IgnoreRule
+	if err := expectKind(node, yaml.MappingNode, "ignore rule"); err != nil {
+		return rule, err
+	}
+	err := forEachMember(node, func(key, value *yaml.Node) error {
+		var err error
+		switch key.Value {
+		case "path":
+			rule.Path = Path(value.Value)
+			_, err = parseJsonPath(rule.Path)
+		case "ifEmpty":
+			err = value.Decode(&rule.IfEmpty)
+		case "ifOmitted":
+			err = value.Decode(&rule.IfOmitted)
+		case "ifPrefix":
+			err = value.Decode(&rule.IfPrefix)
+		case "field":
+			err = value.Decode(&rule.Field)
+		default:
+			return configError(key, fmt.Sprintf("unknown field %q", key.Value))
+		}
+		if err != nil {
+			return configError(value, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return rule, err
+	}
+	if err := (&pathNode{}).addIgnoreRule(rule); err != nil {
+		return rule, configError(node, err.Error())
+	}
+	return rule, nil
+}
+
+// forEachMember calls fn for every key and value of a mapping node, rejecting duplicate keys.
+func forEachMember(node *yaml.Node, fn func(key, value *yaml.Node) error) error {
+	seen := make(map[string]struct{}, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if _, ok := seen[key.Value]; ok {
+			return configError(key, fmt.Sprintf("duplicate key %q", key.Value))
+		}
+		seen[key.Value] = struct{}{}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachPath(node *yaml.Node, field string, fn func(path *yaml.Node) error) error {
+	if err := expectKind(node, yaml.SequenceNode, field); err != nil {
+		return err
+	}
+	for _, path := range node.Content {
+		if path.Kind != yaml.ScalarNode {
+			return configError(path, fmt.Sprintf("%s must be a list of paths", field))
+		}
+		if err := fn(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expectKind(node *yaml.Node, kind yaml.Kind, field string) error {
+	if node.Kind == kind {
+		return nil
+	}
+	if kind == yaml.MappingNode {
+		return configError(node, fmt.Sprintf("%s must be a mapping", field))
+	}
+	return configError(node, fmt.Sprintf("%s must be a list", field))
+}
+
+func configError(node *yaml.Node, msg string) *ConfigError {
+	return &ConfigError{Line: node.Line, Column: node.Column, Msg: msg}
+}
+
+type configFile struct {
+	Version   int                           `yaml:"version"`
+	Resources map[string]resourceConfigFile `yaml:"resources"`
+}
+
+type resourceConfigFile struct {
+	Strategy        PatchStrategy        `yaml:"strategy"`
+	EntitySets      EntitySets           `yaml:"entitySets,omitempty"`
+	Arrays          []Path               `yaml:"arrays,omitempty"`
+	IgnoredFields   []Path               `yaml:"ignoredFields,omitempty"`
+	IncludedFields  []Path               `yaml:"includedFields,omitempty"`
+	ImmutableFields []Path               `yaml:"immutableFields,omitempty"`
+	AtomicFields    []Path               `yaml:"atomicFields,omitempty"`
+	IgnoreRules     []IgnoreRule         `yaml:"ignoreRules,omitempty"`
+	Equivalences    map[Path]Equivalence `yaml:"equivalences,omitempty"`
+	Defaults        map[Path]any         `yaml:"defaults,omitempty"`
+}
+
+// Marshal returns the config as YAML that ParseConfig reads back.
+func (c *Config) Marshal() ([]byte, error) {
+	file := configFile{Version: ConfigVersion, Resources: make(map[string]resourceConfigFile, len(c.Resources))}
+	for name, resource := range c.Resources {
+		strategy := resource.Strategy
+		if strategy == "" {
+			strategy = PatchStrategyExactMatch
+		}
+		file.Resources[name] = resourceConfigFile{
+			Strategy:        strategy,
+			EntitySets:      resource.Collections.EntitySets,
+			Arrays:          resource.Collections.Arrays,
+			IgnoredFields:   resource.Collections.IgnoredFields,
+			IncludedFields:  resource.Collections.IncludedFields,
+			ImmutableFields: resource.Collections.ImmutableFields,
+			AtomicFields:    resource.Collections.AtomicFields,
+			IgnoreRules:     resource.Collections.IgnoreRules,
+			Equivalences:    resource.Collections.Equivalences,
+			Defaults:        resource.Collections.Defaults,
+		}
+	}
+	return yaml.Marshal(file)
+}
@@ -0,0 +1,91 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DriftKind says how a document differs from the desired one.
+type DriftKind string
+
+const (
+	// DriftMissing is a value in the desired document that is not in the actual one.
+	DriftMissing DriftKind = "missing"
+	// DriftUnexpected is a value in the actual document that is not in the desired one.
+	DriftUnexpected DriftKind = "unexpected"
+	// DriftChanged is a value that differs between both documents.
+	DriftChanged DriftKind = "changed"
+	// DriftMismatch is a value that does not satisfy the matcher in the desired document.
+	DriftMismatch DriftKind = "mismatch"
+)
+
+// DriftReason is the first difference HasDrift found.
+type DriftReason struct {
+	Path string
+	Kind DriftKind
+	// Desired is the desired value, or the matcher for DriftMismatch. It is nil for DriftUnexpected.
+	Desired any
+}
+
+func (r *DriftReason) String() string {
+	return fmt.Sprintf("%s value at %q", r.Kind, r.Path)
+}
+
+// HasDrift reports whether CreatePlan would find any difference between `a` and `b`, a change that requires
+// replacement or a Mismatch included. Sets, entity sets, IgnoredFields and the strategy are treated the same, but the
+// diff stops at the first difference and returns its path and kind instead of the whole patch.
+func HasDrift(a, b []byte, collections Collections, strategy PatchStrategy) (bool, *DriftReason, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return false, nil, err
+	}
+	return compiled.HasDrift(a, b, strategy)
+}
+
+// HasDrift checks for drift like the package level HasDrift, using the compiled collections.
+func (c *CompiledCollections) HasDrift(a, b []byte, strategy PatchStrategy) (bool, *DriftReason, error) {
+	aWithoutIgnoredFields, bWithoutIgnoredFields, err := c.unmarshalDiffedDocuments(a, b)
+	if err != nil {
+		return false, nil, err
+	}
+	// Stopping early needs the members to be visited in order, so the drift check never uses a worker pool.
+	s := newDiffState(c, options{})
+	s.firstDrift = true
+	patch, err := handleValues(aWithoutIgnoredFields, bWithoutIgnoredFields, "", []JsonPatchOperation{}, strategy, c, s)
+	if err != nil {
+		return false, nil, err
+	}
+	if patch, err = withoutMatchersInValues(withoutTombstonesInValues(patch), s); err != nil {
+		return false, nil, err
+	}
+
+	if mismatches := s.sortedMismatches(); len(mismatches) > 0 {
+		return true, &DriftReason{Path: mismatches[0].Path, Kind: DriftMismatch, Desired: mismatches[0].Matcher}, nil
+	}
+	if len(patch) == 0 {
+		return false, nil, nil
+	}
+	reason := &DriftReason{Path: patch[0].Path, Desired: patch[0].Value}
+	switch patch[0].Operation {
+	case "add":
+		reason.Kind = DriftMissing
+	case "remove":
+		reason.Kind = DriftUnexpected
+	default:
+		reason.Kind = DriftChanged
+	}
+	return true, reason, nil
+}
+
+// stop returns true once a drift check has found a difference, either in patch or as a Mismatch. It always returns
+// false when the whole patch is computed.
+func (s *diffState) stop(patch []JsonPatchOperation) bool {
+	if !s.firstDrift {
+		return false
+	}
+	if !s.drifted {
+		// Adding a tombstone is no change, those operations are left out of the patch.
+		s.drifted = len(s.mismatches) > 0 || slices.ContainsFunc(patch, func(op JsonPatchOperation) bool { return !isTombstone(op.Value) })
+	}
+	return s.drifted
+}
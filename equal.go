@@ -0,0 +1,39 @@
+package jsonpatch
+
+// Equal reports whether the documents `a` and `b` are the same under the collections: sets and entity sets are compared
+// regardless of the order of their elements, Arrays in order, and the IgnoredFields are left out.
+func Equal(a, b []byte, collections Collections) (bool, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return false, err
+	}
+	return compiled.Equal(a, b)
+}
+
+// Equal compares the documents like the package level Equal, using the compiled collections.
+func (c *CompiledCollections) Equal(a, b []byte) (bool, error) {
+	aWithoutIgnoredFields, bWithoutIgnoredFields, err := c.unmarshalDocuments(a, b)
+	if err != nil {
+		return false, err
+	}
+	return newValueHasher(c).equal(aWithoutIgnoredFields, bWithoutIgnoredFields, c.root), nil
+}
+
+// Hash returns a hash of `doc` under the collections. Documents that are Equal have the same hash. The hash only
+// depends on the decoded values, not on map iteration order or the process, so it can be stored and compared later.
+func Hash(doc []byte, collections Collections) (uint64, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return 0, err
+	}
+	return compiled.Hash(doc)
+}
+
+// Hash hashes the document like the package level Hash, using the compiled collections.
+func (c *CompiledCollections) Hash(doc []byte) (uint64, error) {
+	withoutIgnoredFields, err := c.unmarshalDocument(doc)
+	if err != nil {
+		return 0, err
+	}
+	return newValueHasher(c).hash(withoutIgnoredFields, c.root), nil
+}
@@ -0,0 +1,219 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Equivalence declares values that are treated as if the member holding them were missing. Many APIs return `null`,
+// `[]` or `{}` for fields that were never set, without an equivalence these differ from the missing member in the
+// desired document on every diff.
+//
+// An equivalence in Collections.Equivalences applies to the members at its path and everywhere below it, `$` applies
+// to the whole document. Members holding an equivalent value are left out of both documents before they are compared,
+// so `{"a": null}` and `{}` are equal with NullIsMissing. A null in the desired document means the member is not
+// managed, just like a missing one, so it can not be used to set a member to null. An equivalent value in the actual
+// document is still replaced by a different desired one.
+type Equivalence int
+
+const (
+	// NullIsMissing treats null as missing.
+	NullIsMissing Equivalence = 1 << iota
+	// EmptyIsMissing treats empty arrays and objects as missing, also those that are empty once their equivalent
+	// members are left out.
+	EmptyIsMissing
+	// DefaultIsMissing treats the zero values false, 0 and "" as missing. The values in Collections.Defaults are
+	// treated as missing in actual documents, whether this is set or not.
+	DefaultIsMissing
+)
+
+var equivalenceNames = []struct {
+	equivalence Equivalence
+	name        string
+}{
+	{NullIsMissing, "null"},
+	{EmptyIsMissing, "empty"},
+	{DefaultIsMissing, "default"},
+}
+
+// ParseEquivalence returns the equivalence with the name `null`, `empty` or `default`, as used in config files.
+func ParseEquivalence(name string) (Equivalence, error) {
+	for _, e := range equivalenceNames {
+		if e.name == name {
+			return e.equivalence, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown equivalence %q, expected null, empty or default", name)
+}
+
+// Names returns the names of the equivalences in e.
+func (e Equivalence) Names() []string {
+	names := []string{}
+	for _, n := range equivalenceNames {
+		if e&n.equivalence != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (e Equivalence) String() string {
+	return strings.Join(e.Names(), "|")
+}
+
+func (e Equivalence) MarshalYAML() (any, error) {
+	return e.Names(), nil
+}
+
+// isMissing returns true if v is equivalent to a missing value.
+func (e Equivalence) isMissing(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return e&NullIsMissing != 0
+	case map[string]any:
+		return e&EmptyIsMissing != 0 && len(t) == 0
+	case []any:
+		return e&EmptyIsMissing != 0 && len(t) == 0
+	case bool:
+		return e&DefaultIsMissing != 0 && !t
+	case float64:
+		return e&DefaultIsMissing != 0 && t == 0
+	case string:
+		return e&DefaultIsMissing != 0 && t == ""
+	}
+	return false
+}
+
+func (n *pathNode) equivalenceOf() Equivalence {
+	if n == nil {
+		return 0
+	}
+	return n.equivalence
+}
+
+// withoutMissingEquivalents returns v, whose path compiled to node, without the object members below it that hold a
+// value equivalent to a missing one, or their default if `defaults` is set. `inherited` are the equivalences that
+// apply above node.
+func withoutMissingEquivalents(v any, node *pathNode, inherited Equivalence, defaults bool) any {
+	equivalence := inherited | node.equivalenceOf()
+	switch t := v.(type) {
+	case map[string]any:
+		kept := make(map[string]any, len(t))
+		for key, member := range t {
+			child := node.member(key)
+			value := withoutMissingEquivalents(member, child, equivalence, defaults)
+			if (equivalence | child.equivalenceOf()).isMissing(value) || defaults && child.isDefault(value) {
+				continue
+			}
+			kept[key] = value
+		}
+		return kept
+	case []any:
+		// Elements are kept, leaving them out would shift the indexes of the patch.
+		kept := make([]any, len(t))
+		for i, element := range t {
+			kept[i] = withoutMissingEquivalents(element, node.elements(), equivalence, defaults)
+		}
+		return kept
+	}
+	return v
+}
+
+// withoutUnsetEquivalents returns the actual value `a`, whose path compiled to node, without the object members below
+// it that hold a value equivalent to a missing one or their default, unless the desired value `b` sets them. `b` must
+// not hold equivalent values itself, a member that holds one in `a` and is set in `b` is diffed.
+func withoutUnsetEquivalents(a, b any, node *pathNode, inherited Equivalence) any {
+	equivalence := inherited | node.equivalenceOf()
+	switch at := a.(type) {
+	case map[string]any:
+		if bt, ok := b.(map[string]any); ok {
+			return withoutUnsetMembers(at, bt, node, equivalence)
+		}
+	case []any:
+		if bt, ok := b.([]any); ok {
+			return withoutUnsetElements(at, bt, node, equivalence)
+		}
+	}
+	return withoutMissingEquivalents(a, node, inherited, true)
+}
+
+func withoutUnsetMembers(a, b map[string]any, node *pathNode, equivalence Equivalence) any {
+	kept := make(map[string]any, len(a))
+	for key, av := range a {
+		child := node.member(key)
+		if bv, inB := b[key]; inB {
+			kept[key] = withoutUnsetEquivalents(av, bv, child, equivalence)
+			continue
+		}
+		av = withoutMissingEquivalents(av, child, equivalence, true)
+		if !(equivalence | child.equivalenceOf()).isMissing(av) && !child.isDefault(av) {
+			kept[key] = av
+		}
+	}
+	return kept
+}
+
+// withoutUnsetElements pairs the elements of `a` and `b` the way they are diffed: Arrays by index, entity sets by key
+// and sets by the elements that are equal once their equivalent members and defaults are left out. Elements are never
+// left out, that would shift the indexes of the patch.
+func withoutUnsetElements(a, b []any, node *pathNode, equivalence Equivalence) any {
+	elementPath := node.elements()
+	kept := make([]any, len(a))
+	for i, v := range a {
+		kept[i] = withoutMissingEquivalents(v, elementPath, equivalence, true)
+	}
+
+	switch {
+	case node.isArray():
+		for i := range min(len(a), len(b)) {
+			kept[i] = withoutUnsetEquivalents(a[i], b[i], elementPath, equivalence)
+		}
+	case node.isEntitySet():
+		lookup := make(map[string]int, len(b))
+		for j, v := range b {
+			if identity, ok := elementIdentity(v, node.key); ok {
+				lookup[identity] = j
+			}
+		}
+		for i, v := range a {
+			if identity, ok := elementIdentity(v, node.key); ok {
+				if j, found := lookup[identity]; found {
+					kept[i] = withoutUnsetEquivalents(v, b[j], elementPath, equivalence)
+					delete(lookup, identity)
+				}
+			}
+		}
+	default:
+		// Elements that are equal once their defaults are left out are the same element, the actual one is replaced
+		// by the desired one so they are not diffed.
+		withoutDefaults := make([]any, len(b))
+		for j, v := range b {
+			withoutDefaults[j] = withoutMissingEquivalents(v, elementPath, equivalence, true)
+		}
+		inB := newValueHasher(nil).index(withoutDefaults, elementPath)
+		for i, v := range kept {
+			if j := inB.take(v); j >= 0 {
+				kept[i] = b[j]
+			}
+		}
+	}
+	return kept
+}
+
+// isDefault returns true if v is the default of the property at n. The hasher caches hashes by address, so it must
+// not outlive the values that are left out here.
+func (n *pathNode) isDefault(v any) bool {
+	return n != nil && n.hasDefault && newValueHasher(nil).equal(v, n.defaultValue, n)
+}
+
+// decodedJson returns v as json.Unmarshal would decode its encoding, e.g. numbers as float64.
+func decodedJson(v any) (any, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	err = json.Unmarshal(encoded, &decoded)
+	return decoded, err
+}
@@ -0,0 +1,296 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// valueHasher computes structural hashes over decoded Json values (the output of json.Unmarshal into `any`).
+// Arrays declared in Collections.Arrays are hashed in order, every other array is treated as a set and hashed
+// order-insensitively, matching the way the diff itself treats them. Values are hashed together with the trie node
+// of their path, nil for paths without any declared collection.
+//
+// Hashes of objects and arrays are cached per node, so a subtree is only hashed once no matter how many set
+// comparisons it takes part in. The hasher must not be used on documents that are mutated after hashing.
+// It is only safe for concurrent use when mu is set.
+type valueHasher struct {
+	collections *CompiledCollections
+	cache       map[nodeKey]uint64
+	mu          *sync.Mutex // guards cache when the diff runs concurrently, nil otherwise
+}
+
+// nodeKey identifies an object or array node by its backing storage and the trie node of its path.
+type nodeKey struct {
+	ptr  uintptr
+	len  int
+	path *pathNode
+}
+
+const (
+	hashSeedNull   uint64 = 0x9e3779b97f4a7c15
+	hashSeedFalse  uint64 = 0xbf58476d1ce4e5b9
+	hashSeedTrue   uint64 = 0x94d049bb133111eb
+	hashSeedNumber uint64 = 0x2545f4914f6cdd1d
+	hashSeedString uint64 = 0xd6e8feb86659fd93
+	hashSeedObject uint64 = 0xa0761d6478bd642f
+	hashSeedArray  uint64 = 0xe7037ed1a0b428db
+	hashSeedSet    uint64 = 0x8ebc6af09c88c6e3
+	hashSeedOther  uint64 = 0x589965cc75374cc3
+
+	smallSet = 8
+
+	fnvOffset uint64 = 14695981039346656037
+	fnvPrime  uint64 = 1099511628211
+)
+
+func newValueHasher(collections *CompiledCollections) *valueHasher {
+	return &valueHasher{
+		collections: collections,
+		cache:       make(map[nodeKey]uint64),
+	}
+}
+
+// hash returns the structural hash of `v`, whose path compiled to `path`.
+func (h *valueHasher) hash(v any, path *pathNode) uint64 {
+	switch t := v.(type) {
+	case nil:
+		return hashSeedNull
+	case bool:
+		if t {
+			return hashSeedTrue
+		}
+		return hashSeedFalse
+	case float64:
+		if t == 0 {
+			t = 0 // fold -0 into 0, they compare equal
+		}
+		return mix(hashSeedNumber ^ math.Float64bits(t))
+	case string:
+		return mix(hashSeedString ^ hashString(t))
+	case map[string]any:
+		key := nodeKey{ptr: reflect.ValueOf(t).Pointer(), len: len(t), path: path}
+		if cached, ok := h.cached(key); ok {
+			return cached
+		}
+		// Sum the entries so the result does not depend on map iteration order.
+		sum := hashSeedObject
+		for k, child := range t {
+			sum += mix(hashString(k)*fnvPrime ^ h.hash(child, path.member(k)))
+		}
+		sum = mix(sum)
+		h.store(key, sum)
+		return sum
+	case []any:
+		if len(t) == 0 {
+			return hashSeedArray
+		}
+		key := nodeKey{ptr: reflect.ValueOf(t).Pointer(), len: len(t), path: path}
+		if cached, ok := h.cached(key); ok {
+			return cached
+		}
+		elementPath := path.elements()
+		var sum uint64
+		if path.isArray() {
+			sum = hashSeedArray
+			for _, child := range t {
+				sum = mix(sum*fnvPrime ^ h.hash(child, elementPath))
+			}
+		} else {
+			// Sets are order-insensitive, so their elements are summed rather than folded.
+			sum = hashSeedSet
+			for _, child := range t {
+				sum += mix(h.hash(child, elementPath))
+			}
+			sum = mix(sum)
+		}
+		h.store(key, sum)
+		return sum
+	default:
+		// Not a decoded Json value, fall back to its Json encoding.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return hashSeedOther
+		}
+		return mix(hashSeedOther ^ hashString(string(b)))
+	}
+}
+
+func (h *valueHasher) cached(key nodeKey) (uint64, bool) {
+	if h.mu != nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+	}
+	sum, ok := h.cache[key]
+	return sum, ok
+}
+
+func (h *valueHasher) store(key nodeKey, sum uint64) {
+	if h.mu != nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+	}
+	h.cache[key] = sum
+}
+
+// equal reports whether `a` and `b` are structurally equal, treating arrays that are not declared in
+// Collections.Arrays as sets. It is used to confirm a match after two hashes compare equal.
+func (h *valueHasher) equal(a, b any, path *pathNode) bool {
+	switch at := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		bt, ok := b.(bool)
+		return ok && at == bt
+	case float64:
+		bt, ok := b.(float64)
+		return ok && at == bt
+	case string:
+		bt, ok := b.(string)
+		return ok && at == bt
+	case map[string]any:
+		bt, ok := b.(map[string]any)
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k, av := range at {
+			bv, ok := bt[k]
+			if !ok || !h.equal(av, bv, path.member(k)) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bt, ok := b.([]any)
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		elementPath := path.elements()
+		if path.isArray() {
+			for i := range at {
+				if !h.equal(at[i], bt[i], elementPath) {
+					return false
+				}
+			}
+			return true
+		}
+		return h.setEqual(at, bt, elementPath)
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// setEqual reports whether `a` and `b` hold the same elements, with the same number of duplicates, in any order.
+func (h *valueHasher) setEqual(a, b []any, elementPath *pathNode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	if len(a) <= smallSet {
+		// Pairing up a handful of elements directly is cheaper than building an index.
+		var taken [smallSet]bool
+	next:
+		for _, v := range a {
+			for i, w := range b {
+				if !taken[i] && h.equal(v, w, elementPath) {
+					taken[i] = true
+					continue next
+				}
+			}
+			return false
+		}
+		return true
+	}
+	index := h.index(b, elementPath)
+	for _, v := range a {
+		if index.take(v) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashIndex buckets the elements of an array by hash, so lookups only compare elements that can be equal.
+type hashIndex struct {
+	hasher      *valueHasher
+	elementPath *pathNode
+	values      []any
+	buckets     map[uint64][]int
+}
+
+func (h *valueHasher) index(values []any, elementPath *pathNode) *hashIndex {
+	buckets := make(map[uint64][]int, len(values))
+	for i, v := range values {
+		hv := h.hash(v, elementPath)
+		buckets[hv] = append(buckets[hv], i)
+	}
+	return &hashIndex{hasher: h, elementPath: elementPath, values: values, buckets: buckets}
+}
+
+// contains reports whether the index holds an element equal to `v`.
+func (x *hashIndex) contains(v any) bool {
+	for _, i := range x.buckets[x.hasher.hash(v, x.elementPath)] {
+		if x.hasher.equal(v, x.values[i], x.elementPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// take finds the lowest-indexed element equal to `v` that has not been taken yet, removes it from the index and
+// returns its index. It returns -1 if there is no such element.
+func (x *hashIndex) take(v any) int {
+	hv := x.hasher.hash(v, x.elementPath)
+	bucket := x.buckets[hv]
+	for n, i := range bucket {
+		if x.hasher.equal(v, x.values[i], x.elementPath) {
+			if n == 0 {
+				x.buckets[hv] = bucket[1:]
+			} else {
+				x.buckets[hv] = append(bucket[:n:n], bucket[n+1:]...)
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// isIndex reports whether strconv.Atoi would accept `key`, without allocating an error when it does not.
+func isIndex(key string) bool {
+	if key != "" && (key[0] == '+' || key[0] == '-') {
+		key = key[1:]
+	}
+	if key == "" {
+		return false
+	}
+	if len(key) > 18 {
+		_, err := strconv.Atoi(key)
+		return err == nil
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '0' || key[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func hashString(s string) uint64 {
+	h := fnvOffset
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+// mix is the splitmix64 finalizer, it spreads the bits of `x` so sums of mixed values do not cancel out.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,195 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IgnoreRule ignores a field while diffing, depending on its value in the actual and the desired document. Unlike
+// IgnoredFields, the field is still in both documents, it is just not changed. A rule holds when all of its conditions
+// hold, a field is ignored when any of its rules holds.
+//
+// Path is either a field, e.g. `$.Description`, or the elements of a set or an entity set, e.g. `$.Tags[*]`. An
+// ignored element is kept as it is in the actual document, whatever the desired document holds for it.
+type IgnoreRule struct {
+	Path Path `yaml:"path"`
+	// IfEmpty holds when the value is missing, null, "", [] or {} in either document.
+	IfEmpty bool `yaml:"ifEmpty,omitempty"`
+	// IfOmitted holds when the desired document does not have the value. Only elements can be omitted, object members
+	// the desired document does not have are never changed anyway, so it can only be used with a path ending in `[*]`.
+	IfOmitted bool `yaml:"ifOmitted,omitempty"`
+	// IfPrefix holds when the value, or its member Field if that is set, is a string starting with IfPrefix in either
+	// document.
+	IfPrefix string `yaml:"ifPrefix,omitempty"`
+	Field    string `yaml:"field,omitempty"`
+	// If holds when it returns true. It cannot be written in a config file.
+	If IgnorePredicate `yaml:"-"`
+}
+
+// IgnorePredicate decides whether an IgnoreRule holds for the actual and desired value of a field. inActual and
+// inDesired are false when the document does not have the value, the value is nil then. inDesired is always true for
+// object members, only elements are diffed when the desired document does not have them.
+type IgnorePredicate func(actual, desired any, inActual, inDesired bool) bool
+
+// addIgnoreRule adds the rule to the node at its path.
+func (n *pathNode) addIgnoreRule(rule IgnoreRule) error {
+	segments, err := parseJsonPath(rule.Path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(segments) == 0:
+		return fmt.Errorf("invalid ignore rule %q: cannot ignore the whole document", rule.Path)
+	case !rule.IfEmpty && !rule.IfOmitted && rule.IfPrefix == "" && rule.If == nil:
+		return fmt.Errorf("invalid ignore rule %q: no condition, use IgnoredFields instead", rule.Path)
+	case rule.Field != "" && rule.IfPrefix == "":
+		return fmt.Errorf("invalid ignore rule %q: field is only used with a prefix", rule.Path)
+	case rule.IfOmitted && segments[len(segments)-1] != "[*]":
+		return fmt.Errorf("invalid ignore rule %q: only elements can be omitted, members missing in the desired document are never changed", rule.Path)
+	}
+	if segments[len(segments)-1] == "[*]" && n.insert(segments[:len(segments)-1]).array {
+		return fmt.Errorf("invalid ignore rule %q: the elements of Arrays cannot be ignored", rule.Path)
+	}
+	node := n.insert(segments)
+	node.ignoreRules = append(node.ignoreRules, rule)
+	return nil
+}
+
+// ignores returns true if any of the rules at p holds for the actual value av and the desired value bv.
+func (c *CompiledCollections) ignores(p string, av any, inA bool, bv any, inB bool) bool {
+	if len(c.collections.IgnoreRules) == 0 {
+		return false
+	}
+	return c.lookup(p).ignores(av, inA, bv, inB)
+}
+
+func (n *pathNode) ignores(av any, inA bool, bv any, inB bool) bool {
+	if n == nil {
+		return false
+	}
+	for _, rule := range n.ignoreRules {
+		if rule.holds(av, inA, bv, inB) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r IgnoreRule) holds(av any, inA bool, bv any, inB bool) bool {
+	if r.IfEmpty && !isEmptyValue(av, inA) && !isEmptyValue(bv, inB) {
+		return false
+	}
+	if r.IfOmitted && inB {
+		return false
+	}
+	if r.IfPrefix != "" && !r.hasPrefix(av, inA) && !r.hasPrefix(bv, inB) {
+		return false
+	}
+	return r.If == nil || r.If(av, bv, inA, inB)
+}
+
+func (r IgnoreRule) hasPrefix(v any, found bool) bool {
+	if !found {
+		return false
+	}
+	if r.Field != "" {
+		object, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		v = object[r.Field]
+	}
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, r.IfPrefix)
+}
+
+func isEmptyValue(v any, found bool) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case []any:
+		return len(t) == 0
+	case map[string]any:
+		return len(t) == 0
+	}
+	return !found
+}
+
+// withoutIgnoredElements returns the desired elements bv of the set or entity set at p, with the ignored elements
+// taken from the actual elements av instead. Elements of sets are paired up when they are equal, elements of entity
+// sets when they have the same key.
+func (c *CompiledCollections) withoutIgnoredElements(av, bv []any, p string, h *valueHasher) []any {
+	if len(c.collections.IgnoreRules) == 0 {
+		return bv
+	}
+	node := c.lookup(p)
+	elements := node.elements()
+	if elements == nil || len(elements.ignoreRules) == 0 {
+		return bv
+	}
+
+	// counterparts[j] is the index of the actual element paired with bv[j], or -1.
+	counterparts := make([]int, len(bv))
+	for j := range counterparts {
+		counterparts[j] = -1
+	}
+	if node.isEntitySet() {
+		lookup := make(map[string]int, len(bv))
+		for j, v := range bv {
+			if identity, ok := elementIdentity(v, node.key); ok {
+				lookup[identity] = j
+			}
+		}
+		for i, v := range av {
+			if identity, ok := elementIdentity(v, node.key); ok {
+				if j, found := lookup[identity]; found && counterparts[j] < 0 {
+					counterparts[j] = i
+				}
+			}
+		}
+	} else {
+		index := h.index(bv, elements)
+		for i, v := range av {
+			if j := index.take(v); j >= 0 {
+				counterparts[j] = i
+			}
+		}
+	}
+
+	paired := make([]bool, len(av))
+	desired := make([]any, len(av))
+	for j, i := range counterparts {
+		if i >= 0 {
+			paired[i], desired[i] = true, bv[j]
+		}
+	}
+	ignored := make([]bool, len(av))
+	for i, v := range av {
+		ignored[i] = elements.ignores(v, true, desired[i], paired[i])
+	}
+
+	kept := make([]any, 0, len(bv))
+	for j, v := range bv {
+		if i := counterparts[j]; i >= 0 && ignored[i] || i < 0 && elements.ignores(nil, false, v, true) {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	for i, v := range av {
+		if ignored[i] {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// elementIdentity returns the identity of an entity set element, false if it is not an object or has no identity.
+func elementIdentity(v any, key Key) (string, bool) {
+	if _, ok := v.(map[string]any); !ok {
+		return "", false
+	}
+	identity, err := entityIdentity(v, key)
+	return identity, err == nil
+}
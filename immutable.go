@@ -0,0 +1,77 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan is the result of CreatePlan: the patch that can be applied to the resource, and the changes that cannot.
+type Plan struct {
+	Patch []JsonPatchOperation
+	// RequiresReplacement are the operations on ImmutableFields. The API will reject them, the resource has to be
+	// replaced for them to take effect.
+	RequiresReplacement []JsonPatchOperation
+	// Mismatches are the values that do not satisfy the matchers in the desired document.
+	Mismatches []Mismatch
+}
+
+// ImmutableFieldError is returned in strict mode, see WithStrictImmutableFields, when a patch changes any of the
+// ImmutableFields.
+type ImmutableFieldError struct {
+	Operations []JsonPatchOperation
+}
+
+func (e *ImmutableFieldError) Error() string {
+	paths := make([]string, len(e.Operations))
+	for i, op := range e.Operations {
+		paths[i] = op.Path
+	}
+	return fmt.Sprintf("changes to immutable fields require replacement: %s", strings.Join(paths, ", "))
+}
+
+// CreatePlan creates a patch like CreatePatch, but returns the operations on ImmutableFields separately instead of
+// leaving them out. An operation requires replacement if its path is one of the ImmutableFields or is below one of
+// them.
+func CreatePlan(a, b []byte, collections Collections, strategy PatchStrategy, opts ...Option) (*Plan, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.CreatePlan(a, b, strategy, opts...)
+}
+
+// CreatePlan creates a plan like the package level CreatePlan, using the compiled collections.
+func (c *CompiledCollections) CreatePlan(a, b []byte, strategy PatchStrategy, opts ...Option) (*Plan, error) {
+	o := newOptions(opts)
+	patch, mismatches, err := c.createPatch(a, b, strategy, o)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := c.plan(patch, o)
+	if err != nil {
+		return nil, err
+	}
+	plan.Mismatches = mismatches
+	return plan, nil
+}
+
+// plan splits the operations on ImmutableFields off the patch.
+func (c *CompiledCollections) plan(patch []JsonPatchOperation, o options) (*Plan, error) {
+	plan := &Plan{Patch: patch, RequiresReplacement: []JsonPatchOperation{}}
+	if len(c.collections.ImmutableFields) == 0 {
+		return plan, nil
+	}
+
+	plan.Patch = make([]JsonPatchOperation, 0, len(patch))
+	for _, op := range patch {
+		if c.isImmutable(op.Path) {
+			plan.RequiresReplacement = append(plan.RequiresReplacement, op)
+		} else {
+			plan.Patch = append(plan.Patch, op)
+		}
+	}
+	if o.strictImmutableFields && len(plan.RequiresReplacement) > 0 {
+		return nil, &ImmutableFieldError{Operations: plan.RequiresReplacement}
+	}
+	return plan, nil
+}
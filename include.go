@@ -0,0 +1,168 @@
+package jsonpatch
+
+// include marks the node at `segments` as included, and the nodes above it as including it.
+func (n *pathNode) include(segments []string) {
+	n.includes = true
+	node := n
+	for i := range segments {
+		node = node.insert(segments[i : i+1])
+		node.includes = true
+	}
+	node.included = true
+}
+
+// removeExcludedFields removes everything that is not compared from the decoded document v: the fields that are not
+// diffed and the members that are equivalent to missing ones or hold their default.
+func (c *CompiledCollections) removeExcludedFields(v any) (any, error) {
+	v, err := c.removeUndiffedFields(v)
+	if err != nil || !c.hasEquivalences() {
+		return v, err
+	}
+	return withoutMissingEquivalents(v, c.root, 0, true), nil
+}
+
+// removeUndiffedFields removes what is not in the IncludedFields, if there are any, and then the IgnoredFields from
+// the decoded document v.
+func (c *CompiledCollections) removeUndiffedFields(v any) (any, error) {
+	if len(c.collections.IncludedFields) > 0 {
+		included, ok := onlyIncluded(v, c.root)
+		if !ok {
+			included = emptied(v)
+		}
+		v = included
+	}
+	return removeIgnoredFields(v, c.collections.IgnoredFields)
+}
+
+// removeMatchingEquivalents removes the members that are equivalent to missing ones from the actual document `a` and
+// the desired document `b` of a diff. Members holding their default are only removed from `a`, where `b` does not set
+// them, so a desired default is diffed like any other value.
+func (c *CompiledCollections) removeMatchingEquivalents(a, b any) (any, any) {
+	if !c.hasEquivalences() {
+		return a, b
+	}
+	b = withoutMissingEquivalents(b, c.root, 0, false)
+	return withoutUnsetEquivalents(a, b, c.root, 0), b
+}
+
+func (c *CompiledCollections) hasEquivalences() bool {
+	return len(c.collections.Equivalences) > 0 || len(c.collections.Defaults) > 0
+}
+
+// onlyIncluded returns the parts of v, whose path compiled to node, that are in the IncludedFields, and false if there
+// are none. Objects that end up empty are left out. Elements are never left out, that would shift the indexes of the
+// patch: elements without included fields are emptied and the elements of entity sets keep their key fields.
+func onlyIncluded(v any, node *pathNode) (any, bool) {
+	if node == nil || !node.includes {
+		return nil, false
+	}
+	if node.included {
+		return v, true
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		included := make(map[string]any, len(t))
+		for key, member := range t {
+			if value, ok := onlyIncluded(member, node.member(key)); ok {
+				included[key] = value
+			}
+		}
+		return included, len(included) > 0
+	case []any:
+		included := make([]any, 0, len(t))
+		for _, element := range t {
+			value, ok := onlyIncluded(element, node.elements())
+			if object, isObject := element.(map[string]any); isObject && node.isEntitySet() {
+				if !ok {
+					value, ok = map[string]any{}, true
+				}
+				for _, field := range node.key.Fields() {
+					if kv, found := object[field]; found {
+						value.(map[string]any)[field] = kv
+					}
+				}
+			}
+			if !ok {
+				value = emptied(element)
+			}
+			included = append(included, value)
+		}
+		return included, len(included) > 0
+	}
+	return nil, false
+}
+
+// keepExcludedElements returns the desired document `b` with the elements of the sets in the actual document `a` that
+// have no included fields added, unless it already has them. Those elements are not diffed, so they are kept as they
+// are instead of being removed.
+func (c *CompiledCollections) keepExcludedElements(a, b any) any {
+	if len(c.collections.IncludedFields) == 0 {
+		return b
+	}
+	return keepExcludedElements(a, b, c.root, newValueHasher(c))
+}
+
+func keepExcludedElements(a, b any, node *pathNode, h *valueHasher) any {
+	if node == nil || !node.includes || node.included {
+		return b
+	}
+	switch at := a.(type) {
+	case map[string]any:
+		bt, ok := b.(map[string]any)
+		if !ok {
+			return b
+		}
+		for key, bv := range bt {
+			if av, found := at[key]; found {
+				bt[key] = keepExcludedElements(av, bv, node.member(key), h)
+			}
+		}
+		return bt
+	case []any:
+		bt, ok := b.([]any)
+		if !ok {
+			return b
+		}
+		elements := node.elements()
+		switch {
+		case node.isArray():
+			for i := range min(len(at), len(bt)) {
+				bt[i] = keepExcludedElements(at[i], bt[i], elements, h)
+			}
+		case node.isEntitySet():
+			lookup := make(map[string]int, len(at))
+			for i, v := range at {
+				if identity, ok := elementIdentity(v, node.key); ok {
+					lookup[identity] = i
+				}
+			}
+			for j, v := range bt {
+				if identity, ok := elementIdentity(v, node.key); ok {
+					if i, found := lookup[identity]; found {
+						bt[j] = keepExcludedElements(at[i], v, elements, h)
+					}
+				}
+			}
+		default:
+			index := h.index(bt, elements)
+			for _, v := range at {
+				if _, included := onlyIncluded(v, elements); !included && index.take(v) < 0 {
+					bt = append(bt, v)
+				}
+			}
+		}
+		return bt
+	}
+	return b
+}
+
+// emptied returns an empty value of the type of v, v itself for scalars.
+func emptied(v any) any {
+	switch v.(type) {
+	case map[string]any:
+		return map[string]any{}
+	case []any:
+		return []any{}
+	}
+	return v
+}
@@ -0,0 +1,306 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ProposalKind is the kind of collection InferCollections proposes for a path.
+type ProposalKind string
+
+const (
+	ProposalEntitySet ProposalKind = "entity-set"
+	ProposalSet       ProposalKind = "set"
+	ProposalArray     ProposalKind = "array"
+)
+
+// inferredKeys are the fields InferCollections considers as entity set keys, in order of preference.
+var inferredKeys = []Key{"id", "name", "key", "arn"}
+
+// Proposal is a single collection proposed by InferCollections, with the evidence it is based on.
+type Proposal struct {
+	Path       Path         `json:"path"`
+	Kind       ProposalKind `json:"kind"`
+	Key        Key          `json:"key,omitempty"`
+	Confidence float64      `json:"confidence"`
+	Reason     string       `json:"reason"`
+}
+
+// Inference is the result of InferCollections. It is meant to be reviewed before it is used.
+type Inference struct {
+	Proposals []Proposal `json:"proposals"`
+}
+
+// InferCollections proposes Collections from sample documents of the same resource type.
+//
+// Arrays of objects that all carry one of the fields `id`, `name`, `key` or `arn`, with a value that is unique within
+// each array, are proposed as entity sets keyed on that field. Arrays of primitives are proposed as sets when the
+// samples list their elements in different orders, and as arrays when the order is consistent or elements repeat.
+// Every proposal comes with a confidence between 0 and 1 and the reason it was made.
+//
+// An error is returned if any of the samples is not a valid Json document.
+func InferCollections(samples ...[]byte) (*Inference, error) {
+	observations := make(map[Path][]arrayObservation)
+	for i, sample := range samples {
+		var doc any
+		if err := json.Unmarshal(sample, &doc); err != nil {
+			return nil, fmt.Errorf("sample %d: %w", i, errBadJsonDoc)
+		}
+		observeArrays(doc, "$", "", i, observations)
+	}
+
+	inference := &Inference{Proposals: []Proposal{}}
+	for _, path := range slices.Sorted(maps.Keys(observations)) {
+		if proposal, ok := proposeCollection(path, observations[path]); ok {
+			inference.Proposals = append(inference.Proposals, proposal)
+		}
+	}
+	return inference, nil
+}
+
+// Collections returns the proposals with at least `minConfidence` as Collections. Sets are the default for arrays, so
+// set proposals do not show up in the result.
+func (inf *Inference) Collections(minConfidence float64) Collections {
+	collections := Collections{EntitySets: EntitySets{}, Arrays: []Path{}}
+	for _, proposal := range inf.Proposals {
+		if proposal.Confidence < minConfidence {
+			continue
+		}
+		switch proposal.Kind {
+		case ProposalEntitySet:
+			collections.EntitySets[proposal.Path] = proposal.Key
+		case ProposalArray:
+			collections.Arrays = append(collections.Arrays, proposal.Path)
+		}
+	}
+	return collections
+}
+
+// Config returns the proposals with at least `minConfidence` as the config for the resource type `resource`, so they
+// can be saved with Config.Marshal.
+func (inf *Inference) Config(resource string, strategy PatchStrategy, minConfidence float64) *Config {
+	return &Config{
+		Version: ConfigVersion,
+		Resources: map[string]ResourceConfig{
+			resource: {Strategy: strategy, Collections: inf.Collections(minConfidence)},
+		},
+	}
+}
+
+// String returns one line per proposal, for review.
+func (inf *Inference) String() string {
+	var sb strings.Builder
+	for _, proposal := range inf.Proposals {
+		fmt.Fprintf(&sb, "%s\t%s", proposal.Path, proposal.Kind)
+		if proposal.Key != "" {
+			fmt.Fprintf(&sb, " (key %s)", proposal.Key)
+		}
+		fmt.Fprintf(&sb, "\t%.2f\t%s\n", proposal.Confidence, proposal.Reason)
+	}
+	return sb.String()
+}
+
+// arrayObservation is an array found in a sample at a Json pointer.
+type arrayObservation struct {
+	sample  int
+	pointer string
+	values  []any
+}
+
+func observeArrays(v any, jsonPath, pointer string, sample int, observations map[Path][]arrayObservation) {
+	switch t := v.(type) {
+	case map[string]any:
+		for key, child := range t {
+			if strings.ContainsAny(key, ".[") || isIndex(key) {
+				continue // Can't be expressed as a Json path in Collections
+			}
+			observeArrays(child, jsonPath+"."+key, makePath(pointer, key), sample, observations)
+		}
+	case []any:
+		observations[Path(jsonPath)] = append(observations[Path(jsonPath)], arrayObservation{sample: sample, pointer: pointer, values: t})
+		for i, child := range t {
+			observeArrays(child, jsonPath+"[*]", makePath(pointer, i), sample, observations)
+		}
+	}
+}
+
+func proposeCollection(path Path, observations []arrayObservation) (Proposal, bool) {
+	objects, primitives, elements := 0, 0, 0
+	for _, observation := range observations {
+		for _, v := range observation.values {
+			elements++
+			switch v.(type) {
+			case map[string]any:
+				objects++
+			case string, float64, bool:
+				primitives++
+			}
+		}
+	}
+
+	switch {
+	case elements == 0:
+		return Proposal{}, false
+	case objects == elements:
+		return proposeEntitySet(path, observations, elements)
+	case primitives == elements:
+		return proposeOrder(path, observations)
+	}
+	return Proposal{}, false
+}
+
+func proposeEntitySet(path Path, observations []arrayObservation, elements int) (Proposal, bool) {
+	// Arrays with a single element can't show that a key is unique, only count the others as evidence.
+	evidence := 0
+	for _, observation := range observations {
+		if len(observation.values) > 1 {
+			evidence++
+		}
+	}
+
+	for _, key := range inferredKeys {
+		if !uniqueInEveryArray(key, observations) {
+			continue
+		}
+		confidence := 0.3
+		if evidence > 0 {
+			confidence = min(0.95, 0.6+0.1*float64(evidence))
+		}
+		return Proposal{
+			Path:       path,
+			Kind:       ProposalEntitySet,
+			Key:        key,
+			Confidence: confidence,
+			Reason:     fmt.Sprintf("field %q is present and unique in all %d elements across %d arrays", key, elements, len(observations)),
+		}, true
+	}
+
+	return Proposal{
+		Path:       path,
+		Kind:       ProposalSet,
+		Confidence: 0.5,
+		Reason:     fmt.Sprintf("none of the fields %v is present and unique in all %d elements", inferredKeys, elements),
+	}, true
+}
+
+func uniqueInEveryArray(key Key, observations []arrayObservation) bool {
+	for _, observation := range observations {
+		seen := make(map[any]struct{}, len(observation.values))
+		for _, v := range observation.values {
+			value, ok := v.(map[string]any)[string(key)]
+			if !ok {
+				return false
+			}
+			switch value.(type) {
+			case string, float64:
+			default:
+				return false
+			}
+			if _, ok := seen[value]; ok {
+				return false
+			}
+			seen[value] = struct{}{}
+		}
+	}
+	return true
+}
+
+// proposeOrder compares the arrays found at the same Json pointer in different samples. Elements they have in common
+// showing up in a different order means order is not significant.
+func proposeOrder(path Path, observations []arrayObservation) (Proposal, bool) {
+	for _, observation := range observations {
+		if hasDuplicates(observation.values) {
+			return Proposal{
+				Path:       path,
+				Kind:       ProposalArray,
+				Confidence: 0.7,
+				Reason:     fmt.Sprintf("elements repeat in sample %d at %s", observation.sample, observation.pointer),
+			}, true
+		}
+	}
+
+	byPointer := make(map[string][]arrayObservation)
+	for _, observation := range observations {
+		byPointer[observation.pointer] = append(byPointer[observation.pointer], observation)
+	}
+	consistent, reordered := 0, 0
+	var example string
+	for _, pointer := range slices.Sorted(maps.Keys(byPointer)) {
+		same := byPointer[pointer]
+		for i := 1; i < len(same); i++ {
+			switch relativeOrder(same[0].values, same[i].values) {
+			case orderConsistent:
+				consistent++
+			case orderDiffers:
+				if reordered == 0 {
+					example = fmt.Sprintf("samples %d and %d order the elements at %s differently", same[0].sample, same[i].sample, pointer)
+				}
+				reordered++
+			}
+		}
+	}
+
+	switch {
+	case reordered > 0:
+		return Proposal{
+			Path:       path,
+			Kind:       ProposalSet,
+			Confidence: min(0.95, 0.7+0.1*float64(reordered)),
+			Reason:     example,
+		}, true
+	case consistent > 0:
+		return Proposal{
+			Path:       path,
+			Kind:       ProposalArray,
+			Confidence: min(0.6, 0.3+0.1*float64(consistent)),
+			Reason:     fmt.Sprintf("%d pairs of samples list their common elements in the same order", consistent),
+		}, true
+	}
+	return Proposal{}, false
+}
+
+type order int
+
+const (
+	orderUnknown order = iota
+	orderConsistent
+	orderDiffers
+)
+
+// relativeOrder compares the order of the elements a and b have in common. Elements must be unique in both.
+func relativeOrder(a, b []any) order {
+	positions := make(map[any]int, len(b))
+	for i, v := range b {
+		positions[v] = i
+	}
+	last, common := -1, 0
+	for _, v := range a {
+		i, ok := positions[v]
+		if !ok {
+			continue
+		}
+		common++
+		if i < last {
+			return orderDiffers
+		}
+		last = i
+	}
+	if common < 2 {
+		return orderUnknown
+	}
+	return orderConsistent
+}
+
+func hasDuplicates(values []any) bool {
+	seen := make(map[any]struct{}, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			return true
+		}
+		seen[v] = struct{}{}
+	}
+	return false
+}
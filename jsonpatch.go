@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"reflect"
 	"slices"
-	"strconv"
 	"strings"
 
 	"github.com/tidwall/gjson"
@@ -23,17 +23,33 @@ type Collections struct {
 	EntitySets    EntitySets
 	Arrays        []Path
 	IgnoredFields []Path
+	// IncludedFields, when set, are the only fields that are diffed, together with the keys of the entity sets they
+	// are in. IgnoredFields are removed from them.
+	IncludedFields []Path
+	// ImmutableFields can only be set when the resource is created, see CreatePlan.
+	ImmutableFields []Path
+	// AtomicFields are replaced as a whole when they differ, instead of being diffed. Arrays among them are compared in
+	// order.
+	AtomicFields []Path
+	// IgnoreRules ignore fields, or elements of sets and entity sets, depending on their values.
+	IgnoreRules []IgnoreRule
+	// Equivalences declare the values that are treated as missing, at and below their paths.
+	Equivalences map[Path]Equivalence
+	// Defaults are the values the API fills in for fields that are not set. An actual field holding its default is
+	// treated as missing, so a desired document without it does not differ from an actual one with it. A desired
+	// field holding its default is diffed like any other value.
+	Defaults map[Path]any
 }
 
-func (c *Collections) isArray(path string) bool {
-	jsonPath := toJsonPath(path)
-	return slices.Contains(c.Arrays, Path(jsonPath))
+// CompositeKey returns the key of an entity set whose elements are identified by several fields together, e.g. the
+// `containerPort` and `protocol` of a container port.
+func CompositeKey(fields ...string) Key {
+	return Key(strings.Join(fields, ","))
 }
 
-func (c *Collections) isEntitySet(path string) bool {
-	jsonPath := toJsonPath(path)
-	_, ok := c.EntitySets[Path(jsonPath)]
-	return ok
+// Fields returns the fields that make up the key, a single one unless it is a CompositeKey.
+func (k Key) Fields() []string {
+	return strings.Split(string(k), ",")
 }
 
 func (s EntitySets) Add(path Path, key Key) {
@@ -51,30 +67,6 @@ func (s EntitySets) Get(path Path) (Key, bool) {
 	return key, ok
 }
 
-func toJsonPath(path string) string {
-	if path == "" || path == "/" {
-		return "$"
-	}
-
-	parts := strings.Split(path, "/")
-	var jsonPathParts []string
-
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-
-		_, err := strconv.Atoi(part)
-		if err == nil {
-			jsonPathParts = append(jsonPathParts, "[*]")
-		} else {
-			jsonPathParts = append(jsonPathParts, "."+part)
-		}
-	}
-
-	return "$" + strings.Join(jsonPathParts, "")
-}
-
 type PatchStrategy string
 
 const (
@@ -95,10 +87,26 @@ func (j *JsonPatchOperation) Json() string {
 }
 
 func (j *JsonPatchOperation) MarshalJson() ([]byte, error) {
+	return j.MarshalJSON()
+}
+
+// MarshalJSON encodes the operation with its value. The operations that need a value, add, replace and test, always
+// have one, even when it is null.
+func (j JsonPatchOperation) MarshalJSON() ([]byte, error) {
 	var b bytes.Buffer
 	b.WriteString("{")
-	b.WriteString(fmt.Sprintf(`"op":"%s"`, j.Operation))
-	b.WriteString(fmt.Sprintf(`,"path":"%s"`, j.Path))
+	op, err := json.Marshal(j.Operation)
+	if err != nil {
+		return nil, err
+	}
+	path, err := json.Marshal(j.Path)
+	if err != nil {
+		return nil, err
+	}
+	b.WriteString(`"op":`)
+	b.Write(op)
+	b.WriteString(`,"path":`)
+	b.Write(path)
 	// Consider omitting Value for non-nullable operations.
 	if j.Value != nil || j.Operation == "replace" || j.Operation == "add" || j.Operation == "test" {
 		v, err := json.Marshal(j.Value)
@@ -128,36 +136,107 @@ func NewPatch(operation, path string, value any) JsonPatchOperation {
 // The function will return an array of JsonPatchOperations
 // If ignoreArrayOrder is true, arrays with the same elements but in different order will be considered equal
 //
-// An e rror will be returned if any of the two documents are invalid.
-func CreatePatch(a, b []byte, collections Collections, strategy PatchStrategy) ([]JsonPatchOperation, error) {
+// An error will be returned if any of the two documents are invalid, or if the collections do not compile.
+// Use Collections.Compile and CompiledCollections.CreatePatch to validate the collections once for many patches.
+//
+// Options such as WithConcurrency change how the patch is computed, but never the resulting patch.
+//
+// Changes to ImmutableFields are left out of the patch, use CreatePlan to get them or WithStrictImmutableFields to
+// turn them into an error.
+func CreatePatch(a, b []byte, collections Collections, strategy PatchStrategy, opts ...Option) ([]JsonPatchOperation, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.CreatePatch(a, b, strategy, opts...)
+}
+
+// CreatePatch creates a patch like the package level CreatePatch, using the compiled collections.
+func (c *CompiledCollections) CreatePatch(a, b []byte, strategy PatchStrategy, opts ...Option) ([]JsonPatchOperation, error) {
+	plan, err := c.CreatePlan(a, b, strategy, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Patch, nil
+}
+
+// createPatch computes the full patch from a to b, including the changes to ImmutableFields.
+func (c *CompiledCollections) createPatch(a, b []byte, strategy PatchStrategy, o options) ([]JsonPatchOperation, []Mismatch, error) {
+	aWithoutIgnoredFields, bWithoutIgnoredFields, err := c.unmarshalDiffedDocuments(a, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := newDiffState(c, o)
+	patch, err := handleValues(aWithoutIgnoredFields, bWithoutIgnoredFields, "", []JsonPatchOperation{}, strategy, c, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if patch, err = withoutMatchersInValues(withoutTombstonesInValues(patch), s); err != nil {
+		return nil, nil, err
+	}
+	return patch, s.sortedMismatches(), nil
+}
+
+// unmarshalDocuments unmarshals the original and the modified document and removes the fields that are not compared
+// from both, see removeExcludedFields.
+func (c *CompiledCollections) unmarshalDocuments(a, b []byte) (any, any, error) {
+	return c.unmarshalDocumentsWith(a, b, c.removeExcludedFields)
+}
+
+// unmarshalDiffedDocuments unmarshals the actual document `a` and the desired document `b` of a diff. Unlike
+// unmarshalDocuments it keeps the defaults the desired document sets and the actual values they are diffed against,
+// see removeMatchingEquivalents, and the set elements that are not included, see keepExcludedElements.
+func (c *CompiledCollections) unmarshalDiffedDocuments(a, b []byte) (any, any, error) {
+	av, bv, err := c.unmarshalDocumentsWith(a, b, c.removeUndiffedFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	av, bv = c.removeMatchingEquivalents(av, bv)
+	return av, c.keepExcludedElements(av, bv), nil
+}
+
+func (c *CompiledCollections) unmarshalDocumentsWith(a, b []byte, remove func(any) (any, error)) (any, any, error) {
 	var aUnmarshalled any
 	var bUnmarshalled any
 
 	err := json.Unmarshal(a, &aUnmarshalled)
 	if err != nil {
-		return nil, errBadJsonDoc
+		return nil, nil, errBadJsonDoc
 	}
 	err = json.Unmarshal(b, &bUnmarshalled)
 	if err != nil {
-		return nil, errBadJsonDoc
+		return nil, nil, errBadJsonDoc
 	}
-	aWithoutIgnoredFields, err := removeIgnoredFields(aUnmarshalled, collections.IgnoredFields)
+	aWithoutIgnoredFields, err := remove(aUnmarshalled)
 	if err != nil {
-		return nil, fmt.Errorf("error removing ignored fields from original document: %w", err)
+		return nil, nil, fmt.Errorf("error removing ignored fields from original document: %w", err)
 	}
-	bWithoutIgnoredFields, err := removeIgnoredFields(bUnmarshalled, collections.IgnoredFields)
+	bWithoutIgnoredFields, err := remove(bUnmarshalled)
 	if err != nil {
-		return nil, fmt.Errorf("error removing ignored fields from modified document: %w", err)
+		return nil, nil, fmt.Errorf("error removing ignored fields from modified document: %w", err)
 	}
+	return aWithoutIgnoredFields, bWithoutIgnoredFields, nil
+}
 
-	return handleValues(aWithoutIgnoredFields, bWithoutIgnoredFields, "", []JsonPatchOperation{}, strategy, collections)
+// unmarshalDocument unmarshals a single document and removes the fields that are not diffed from it.
+func (c *CompiledCollections) unmarshalDocument(doc []byte) (any, error) {
+	var unmarshalled any
+	if err := json.Unmarshal(doc, &unmarshalled); err != nil {
+		return nil, errBadJsonDoc
+	}
+	withoutIgnoredFields, err := c.removeExcludedFields(unmarshalled)
+	if err != nil {
+		return nil, fmt.Errorf("error removing ignored fields: %w", err)
+	}
+	return withoutIgnoredFields, nil
 }
 
 // Returns true if the values matches (must be json types)
 // The types of the values must match, otherwise it will always return false
 // If two map[string]any are given, all elements must match.
-// If ignoreArrayOrder is true and both values are arrays, they are compared as sets
-func matchesValue(av, bv any, ignoreArrayOrder bool) bool {
+// If ignoreArrayOrder is true and both values are arrays, they are compared as sets, using the structural hashes
+// of their elements at path p
+func matchesValue(av, bv any, p string, ignoreArrayOrder bool, h *valueHasher) bool {
 	if reflect.TypeOf(av) != reflect.TypeOf(bv) {
 		return false
 	}
@@ -180,12 +259,12 @@ func matchesValue(av, bv any, ignoreArrayOrder bool) bool {
 	case map[string]any:
 		bt := bv.(map[string]any)
 		for key := range at {
-			if !matchesValue(at[key], bt[key], ignoreArrayOrder) {
+			if !matchesValue(at[key], bt[key], makePath(p, key), ignoreArrayOrder, h) {
 				return false
 			}
 		}
 		for key := range bt {
-			if !matchesValue(at[key], bt[key], ignoreArrayOrder) {
+			if !matchesValue(at[key], bt[key], makePath(p, key), ignoreArrayOrder, h) {
 				return false
 			}
 		}
@@ -198,47 +277,11 @@ func matchesValue(av, bv any, ignoreArrayOrder bool) bool {
 
 		if ignoreArrayOrder {
 			// Check if arrays have the same elements, regardless of order
-			// Create a map of element counts for each array
-			atCount := make(map[string]int)
-			btCount := make(map[string]int)
-
-			// Count elements in first array
-			for _, v := range at {
-				// Convert element to Json string for comparison
-				jsonBytes, err := json.Marshal(v)
-				if err != nil {
-					return false
-				}
-				jsonStr := string(jsonBytes)
-				atCount[jsonStr]++
-			}
-
-			// Count elements in second array
-			for _, v := range bt {
-				jsonBytes, err := json.Marshal(v)
-				if err != nil {
-					return false
-				}
-				jsonStr := string(jsonBytes)
-				btCount[jsonStr]++
-			}
-
-			// Compare counts
-			if len(atCount) != len(btCount) {
-				return false
-			}
-
-			for k, v := range atCount {
-				if btCount[k] != v {
-					return false
-				}
-			}
-
-			return true
+			return h.setEqual(at, bt, h.collections.lookup(p).elements())
 		}
 		// Order matters, check each element in order
 		for key := range at {
-			if !matchesValue(at[key], bt[key], ignoreArrayOrder) {
+			if !matchesValue(at[key], bt[key], makePath(p, key), ignoreArrayOrder, h) {
 				return false
 			}
 		}
@@ -255,9 +298,8 @@ func matchesValue(av, bv any, ignoreArrayOrder bool) bool {
 // character sequence.  This is performed by first transforming any
 // occurrence of the sequence '~1' to '/', and then transforming any
 // occurrence of the sequence '~0' to '~'.
-//   TODO decode support:
-//   var rfc6901Decoder = strings.NewReplacer("~1", "/", "~0", "~")
 
+var rfc6901Decoder = strings.NewReplacer("~1", "/", "~0", "~")
 var rfc6901Encoder = strings.NewReplacer("~", "~0", "/", "~1")
 
 func makePath(path string, newPart any) string {
@@ -272,26 +314,33 @@ func makePath(path string, newPart any) string {
 }
 
 // diff returns the (recursive) difference between a and b as an array of JsonPatchOperations.
-func diff(a, b map[string]any, path string, patch []JsonPatchOperation, strategy PatchStrategy, collections Collections) ([]JsonPatchOperation, error) {
+// Members are visited in key order, so the patch does not depend on map iteration order or on how many workers diff
+// the members.
+func diff(a, b map[string]any, path string, patch []JsonPatchOperation, strategy PatchStrategy, collections *CompiledCollections, s *diffState) ([]JsonPatchOperation, error) {
 	//TODO: handle EnsureAbsent strategy
-	for key, bv := range b {
-		p := makePath(path, key)
-		av, ok := a[key]
-		// If the key is not present in a, add it
-		if !ok {
-			patch = append(patch, NewPatch("add", p, bv))
-			continue
-		}
-		// If types have changed, replace completely
-		if reflect.TypeOf(av) != reflect.TypeOf(bv) {
-			patch = append(patch, NewPatch("replace", p, bv))
-			continue
+	keys := slices.Sorted(maps.Keys(b))
+	if s.pool == nil {
+		for _, key := range keys {
+			var err error
+			patch, err = diffMember(a, b, key, path, patch, strategy, collections, s)
+			if err != nil {
+				return nil, err
+			}
+			if s.stop(patch) {
+				break
+			}
 		}
-		// Types are the same, compare values
-		var err error
-		patch, err = handleValues(av, bv, p, patch, strategy, collections)
-		if err != nil {
-			return nil, err
+	} else {
+		members := make([][]JsonPatchOperation, len(keys))
+		errs := make([]error, len(keys))
+		s.pool.run(len(keys), func(i int) {
+			members[i], errs[i] = diffMember(a, b, keys[i], path, nil, strategy, collections, s)
+		})
+		for i := range keys {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			patch = append(patch, members[i]...)
 		}
 	}
 	// Leaving this here for now, but the current thinking is that we never remove properties from objects.
@@ -308,42 +357,97 @@ func diff(a, b map[string]any, path string, patch []JsonPatchOperation, strategy
 	return patch, nil
 }
 
-func handleValues(av, bv any, p string, patch []JsonPatchOperation, strategy PatchStrategy, collections Collections) ([]JsonPatchOperation, error) {
+// diffMember appends the difference for the member `key` of a and b to patch.
+func diffMember(a, b map[string]any, key, path string, patch []JsonPatchOperation, strategy PatchStrategy, collections *CompiledCollections, s *diffState) ([]JsonPatchOperation, error) {
+	bv := b[key]
+	p := makePath(path, key)
+	av, ok := a[key]
+	if isMatcher, err := s.checkMatcher(av, ok, bv, p); isMatcher || err != nil {
+		return patch, err
+	}
+	if isTombstone(bv) {
+		if ok {
+			patch = append(patch, NewPatch("remove", p, nil))
+		}
+		return patch, nil
+	}
+	if collections.ignores(p, av, ok, bv, true) {
+		return patch, nil
+	}
+	// If the key is not present in a, add it
+	if !ok {
+		return append(patch, NewPatch("add", p, bv)), nil
+	}
+	// If types have changed, replace completely
+	if reflect.TypeOf(av) != reflect.TypeOf(bv) {
+		return append(patch, NewPatch("replace", p, bv)), nil
+	}
+	// Types are the same, compare values
+	return handleValues(av, bv, p, patch, strategy, collections, s)
+}
+
+func handleValues(av, bv any, p string, patch []JsonPatchOperation, strategy PatchStrategy, collections *CompiledCollections, s *diffState) ([]JsonPatchOperation, error) {
 	var err error
+	if isMatcher, err := s.checkMatcher(av, true, bv, p); isMatcher || err != nil {
+		return patch, err
+	}
+	if av != nil && collections.isAtomic(p) {
+		if !matchesValue(av, bv, p, false, s.hasher) {
+			patch = append(patch, NewPatch("replace", p, bv))
+		}
+		return patch, nil
+	}
 	ignoreArrayOrder := !collections.isArray(p)
 	switch at := av.(type) {
 	case map[string]any:
 		bt := bv.(map[string]any)
-		patch, err = diff(at, bt, p, patch, strategy, collections)
+		patch, err = diff(at, bt, p, patch, strategy, collections, s)
 		if err != nil {
 			return nil, err
 		}
 		return patch, nil
 	case string, float64, bool:
-		if !matchesValue(av, bv, ignoreArrayOrder) {
+		if !matchesValue(av, bv, p, ignoreArrayOrder, s.hasher) {
 			patch = append(patch, NewPatch("replace", p, bv))
 		}
 		return patch, nil
 	case []any:
 		bt, replaceWithOtherCollection := bv.([]any)
+		if replaceWithOtherCollection && !collections.isEntitySet(p) && slices.ContainsFunc(bt, isTombstone) {
+			return nil, errTombstoneElement(p)
+		}
+		if replaceWithOtherCollection && !collections.isArray(p) {
+			bt = collections.withoutIgnoredElements(at, bt, p, s.hasher)
+		}
 		switch {
 		case !replaceWithOtherCollection:
 			// If the types are different, we replace the whole array
 			patch = append(patch, NewPatch("replace", p, bv))
 		case collections.isArray(p) && len(at) != len(bt):
-			patch = append(patch, compareArray(at, bt, p, strategy, collections)...)
+			ops, err := compareArray(at, bt, p, strategy, collections, s)
+			if err != nil {
+				return nil, err
+			}
+			patch = append(patch, ops...)
 		case collections.isArray(p) && len(at) == len(bt):
 			// If arrays have the same length, we can compare them element by element
 			for i := range bt {
-				patch, err = handleValues(at[i], bt[i], makePath(p, i), patch, strategy, collections)
+				patch, err = handleValues(at[i], bt[i], makePath(p, i), patch, strategy, collections, s)
 				if err != nil {
 					return nil, err
 				}
+				if s.stop(patch) {
+					break
+				}
 			}
 		default:
 			// If this is not an array, we treat it as a set of values.
-			if !matchesValue(at, bt, true) {
-				patch = append(patch, compareArray(at, bt, p, strategy, collections)...)
+			if !matchesValue(at, bt, p, true, s.hasher) {
+				ops, err := compareArray(at, bt, p, strategy, collections, s)
+				if err != nil {
+					return nil, err
+				}
+				patch = append(patch, ops...)
 			}
 		}
 	case nil:
@@ -360,57 +464,57 @@ func handleValues(av, bv any, p string, patch []JsonPatchOperation, strategy Pat
 }
 
 // compareArray generates remove and add operations for `av` and `bv`.
-func compareArray(av, bv []any, p string, strategy PatchStrategy, collections Collections) []JsonPatchOperation {
+func compareArray(av, bv []any, p string, strategy PatchStrategy, collections *CompiledCollections, s *diffState) ([]JsonPatchOperation, error) {
 	retval := []JsonPatchOperation{}
 
 	switch {
 	case collections.isArray(p):
-		if strategy == PatchStrategyExactMatch {
-			// Find elements that need to be removed
-			processArray(av, bv, func(i int, value any) {
-				retval = append(retval, NewPatch("remove", makePath(p, i), nil))
-			}, strategy)
-			reversed := make([]JsonPatchOperation, len(retval))
-			for i := range retval {
-				reversed[len(retval)-1-i] = retval[i]
-			}
-			retval = reversed
-		}
-
-		// Find elements that need to be added.
-		// NOTE we pass in `bv` then `av` so that processArray can find the missing elements.
-		processArray(bv, av, func(i int, value any) {
-			retval = append(retval, NewPatch("add", makePath(p, i), value))
-		}, strategy)
+		retval = append(retval, orderedArrayPatch(av, bv, p, strategy, s.hasher)...)
 	case collections.isEntitySet(p):
-		if len(av) == len(bv) && matchesValue(av, bv, true) {
-			return retval
+		bv, tombstones := splitTombstones(bv)
+		if len(tombstones) == 0 && len(av) == len(bv) && matchesValue(av, bv, p, true, s.hasher) {
+			return retval, nil
 		}
 		// TODO: removing is not tested yest!
 		removals := 0
-		if strategy == PatchStrategyExactMatch {
+		var removed []int // the indexes of the removed elements, in ascending order
+		switch {
+		case strategy == PatchStrategyExactMatch:
 			// Find elements that need to be removed
 			elementsBeforeRemove := len(retval)
-			processIdentitySet(av, bv, p, func(i, o int, value any) {
+			err := processIdentitySet(av, bv, p, func(i, o int, value any) {
 				retval = append(retval, NewPatch("remove", makePath(p, i), nil))
-			}, func(ops []JsonPatchOperation) { // no-op
-			}, strategy, collections)
+				removed = append(removed, i)
+			}, nil, nil, strategy, collections, s)
+			if err != nil {
+				return nil, err
+			}
 			removals = len(retval) - elementsBeforeRemove
 			reversed := make([]JsonPatchOperation, len(retval))
 			for i := range retval {
 				reversed[len(retval)-1-i] = retval[i]
 			}
 			retval = reversed
+		case len(tombstones) > 0:
+			// Other strategies only remove the elements that are explicitly deleted.
+			removed = deletedEntities(av, tombstones, p, collections)
+			for i := len(removed) - 1; i >= 0; i-- {
+				retval = append(retval, NewPatch("remove", makePath(p, removed[i]), nil))
+			}
+			removals = len(removed)
 		}
 		offset := len(av) - removals
-		processIdentitySet(bv, av, p, func(i, o int, value any) {
+		err := processIdentitySet(bv, av, p, func(i, o int, value any) {
 			retval = append(retval, NewPatch("add", makePath(p, o+offset), value))
 		}, func(ops []JsonPatchOperation) {
 			retval = append(retval, ops...)
-		}, strategy, collections)
+		}, removed, strategy, collections, s)
+		if err != nil {
+			return nil, err
+		}
 	default: // default to set
-		if len(av) == len(bv) && matchesValue(av, bv, true) {
-			return retval
+		if len(av) == len(bv) && matchesValue(av, bv, p, true, s.hasher) {
+			return retval, nil
 		}
 		// TODO: removing is not tested yest!
 		// also we need to check for PatchStrategyEnsureAbsent
@@ -418,7 +522,7 @@ func compareArray(av, bv []any, p string, strategy PatchStrategy, collections Co
 		if strategy == PatchStrategyExactMatch {
 			// Find elements that need to be removed
 			elementsBeforeRemove := len(retval)
-			processSet(av, bv, func(i int, value any) { retval = append(retval, NewPatch("remove", makePath(p, i), nil)) })
+			processSet(av, bv, p, func(i int, value any) { retval = append(retval, NewPatch("remove", makePath(p, i), nil)) }, s.hasher)
 			removals = len(retval) - elementsBeforeRemove
 			reversed := make([]JsonPatchOperation, len(retval))
 			for i := range retval {
@@ -427,87 +531,84 @@ func compareArray(av, bv []any, p string, strategy PatchStrategy, collections Co
 			retval = reversed
 		}
 		offset := len(av) - removals
-		processSet(bv, av, func(i int, value any) { retval = append(retval, NewPatch("add", makePath(p, i+offset), value)) })
+		// Additions go to the end of the set, one after the other.
+		added := 0
+		processSet(bv, av, p, func(_ int, value any) {
+			retval = append(retval, NewPatch("add", makePath(p, offset+added), value))
+			added++
+		}, s.hasher)
 	}
 
-	return retval
+	return retval, nil
 }
 
-func processSet(av, bv []any, applyOp func(i int, value any)) {
-	foundIndexes := make(map[int]struct{}, len(av))
-	lookup := make(map[string]int)
-
-	for i, v := range bv {
-		jsonBytes, err := json.Marshal(v)
-		if err != nil {
-			continue // Skip if we can't marshal
-		}
-		jsonStr := string(jsonBytes)
-		lookup[jsonStr] = i
-	}
-
-	// Check each element in av
-	for i, v := range av {
-		jsonBytes, err := json.Marshal(v)
-		if err != nil {
-			applyOp(i, v) // If we can't marshal, treat it as not found
-			continue
-		}
-
-		jsonStr := string(jsonBytes)
-		// If element exists in bv and we haven't seen all of them yet
-		if _, ok := lookup[jsonStr]; ok {
-			foundIndexes[i] = struct{}{}
-		}
-	}
+func processSet(av, bv []any, p string, applyOp func(i int, value any), h *valueHasher) {
+	lookup := h.index(bv, h.collections.lookup(p).elements())
 
-	// Apply op for all elements in av that weren't found
+	// Apply op for all elements in av that aren't in bv
 	for i, v := range av {
-		if _, ok := foundIndexes[i]; !ok {
+		if !lookup.contains(v) {
 			applyOp(i, v)
 		}
 	}
 }
 
-func processIdentitySet(av, bv []any, path string, applyOp func(i, o int, value any), replaceOps func(ops []JsonPatchOperation), strategy PatchStrategy, collections Collections) {
+// processIdentitySet matches the elements of av and bv on the key of the entity set at path. It calls applyOp for the
+// elements of av that are not in bv, and replaceOps, if set, with the changes to the matched elements of bv. The
+// elements of bv at the indexes in `removed` are removed before those changes are applied, so the changes are shifted
+// accordingly. It returns the first error diffing the matched elements.
+func processIdentitySet(av, bv []any, path string, applyOp func(i, o int, value any), replaceOps func(ops []JsonPatchOperation), removed []int, strategy PatchStrategy, collections *CompiledCollections, s *diffState) error {
 	foundIndexes := make(map[int]struct{}, len(av))
 	lookup := make(map[string]int)
+	var matches [][2]int // pairs of indexes into av and bv
+
+	key, ok := collections.entitySetKey(path)
+	if !ok {
+		return nil // If we don't have a key for this path, skip
+	}
 
 	for i, v := range bv {
-		key, ok := collections.EntitySets.Get(Path(toJsonPath(path)))
-		if !ok {
-			continue // If we don't have a key for this path, skip
-		}
-		jsonBytes, err := json.Marshal(v.(map[string]any)[string(key)])
+		identity, err := entityIdentity(v, key)
 		if err != nil {
 			continue // Skip if we can't marshal
 		}
-		jsonStr := string(jsonBytes)
-		lookup[jsonStr] = i
+		lookup[identity] = i
 	}
 
 	for i, v := range av {
-		key, ok := collections.EntitySets.Get(Path(toJsonPath(path)))
-		if !ok {
-			continue // If we don't have a key for this path, skip
-		}
-		jsonBytes, err := json.Marshal(v.(map[string]any)[string(key)])
+		identity, err := entityIdentity(v, key)
 		if err != nil {
 			applyOp(i, 0, v) // If we can't marshal, treat it as not found
 			continue
 		}
 
-		jsonStr := string(jsonBytes)
-		if index, ok := lookup[jsonStr]; ok {
+		if index, ok := lookup[identity]; ok {
 			foundIndexes[i] = struct{}{}
-			updateOps, err := handleValues(bv[index], v, fmt.Sprintf("%s/%d", path, lookup[jsonStr]), []JsonPatchOperation{}, strategy, collections)
-			if err != nil {
-				return
-			}
-			replaceOps(updateOps)
+			matches = append(matches, [2]int{i, index})
 		}
 	}
 
+	// Matched elements are independent of each other, diff them on the pool and hand the ops over in order.
+	if replaceOps == nil {
+		matches = nil
+	}
+	updates := make([][]JsonPatchOperation, len(matches))
+	errs := make([]error, len(matches))
+	s.pool.run(len(matches), func(m int) {
+		if s.stop(nil) {
+			return
+		}
+		i, index := matches[m][0], matches[m][1]
+		shifted, _ := slices.BinarySearch(removed, index)
+		updates[m], errs[m] = handleValues(bv[index], av[i], fmt.Sprintf("%s/%d", path, index-shifted), []JsonPatchOperation{}, strategy, collections, s)
+	})
+	for m := range matches {
+		if errs[m] != nil {
+			return errs[m]
+		}
+		replaceOps(updates[m])
+	}
+
 	offset := 0
 	for i, v := range av {
 		if _, ok := foundIndexes[i]; !ok {
@@ -515,67 +616,185 @@ func processIdentitySet(av, bv []any, path string, applyOp func(i, o int, value
 			offset++
 		}
 	}
+	return nil
+}
+
+// entityIdentity returns the Json encoding of the key fields of an entity set element.
+func entityIdentity(v any, key Key) (string, error) {
+	element := v.(map[string]any)
+	fields := key.Fields()
+	if len(fields) == 1 {
+		b, err := json.Marshal(element[fields[0]])
+		return string(b), err
+	}
+	values := make([]any, len(fields))
+	for i, field := range fields {
+		values[i] = element[field]
+	}
+	b, err := json.Marshal(values)
+	return string(b), err
 }
 
 // processArray processes `av` and `bv` calling `applyOp` whenever a value is absent.
 // It keeps track of which indexes have already had `applyOp` called for and automatically skips them so you can process duplicate objects correctly.
-func processArray(av, bv []any, applyOp func(i int, value any), strategy PatchStrategy) {
-	foundIndexes := make(map[int]struct{}, len(av))
+func processArray(av, bv []any, p string, applyOp func(i int, value any), strategy PatchStrategy, h *valueHasher) {
 	switch strategy {
 	case PatchStrategyExactMatch:
-		reverseFoundIndexes := make(map[int]struct{}, len(bv))
+		// Bucket bv by hash, every element of av is matched against the first element of bv that is equal and not
+		// matched yet, so duplicates are paired up one by one.
+		unmatched := h.index(bv, h.collections.lookup(p).elements())
 		for i, v := range av {
-			for i2, v2 := range bv {
-				if _, ok := reverseFoundIndexes[i2]; ok {
-					continue
-				}
-				if reflect.DeepEqual(v, v2) {
-					foundIndexes[i] = struct{}{}
-					reverseFoundIndexes[i2] = struct{}{}
-					break
-				}
-			}
-			if _, ok := foundIndexes[i]; !ok {
+			if unmatched.take(v) < 0 {
 				applyOp(i, v)
 			}
 		}
 	case PatchStrategyEnsureExists:
 		offset := len(bv)
-		bvCounts := make(map[string]int)
-		bvSeen := make(map[string]int) // Track how many we've seen during processing
-
-		for _, v := range bv {
-			jsonBytes, err := json.Marshal(v)
-			if err != nil {
-				continue // Skip if we can't marshal
+		// Every element of bv can only account for one element of av, take them out of the lookup as they are found.
+		lookup := h.index(bv, h.collections.lookup(p).elements())
+
+		// Missing elements are appended one after the other.
+		for _, v := range av {
+			if lookup.take(v) < 0 {
+				applyOp(offset, v)
+				offset++
 			}
-			jsonStr := string(jsonBytes)
-			bvCounts[jsonStr]++
 		}
+		return
+	case PatchStrategyEnsureAbsent:
+		return
+	}
+}
 
-		for i, v := range av {
-			jsonBytes, err := json.Marshal(v)
-			if err != nil {
-				applyOp(i+offset, v) // If we can't marshal, treat it as not found
+// orderedArrayPatch returns the operations that turn the Array `av` into `bv`, keeping the elements of a longest common
+// subsequence of both in place. With PatchStrategyExactMatch the other elements of `av` are removed and those of `bv`
+// inserted at their index. With PatchStrategyEnsureExists no element is removed, the missing elements are inserted
+// before the element that follows them in `bv`, so the result holds `bv` in order.
+func orderedArrayPatch(av, bv []any, p string, strategy PatchStrategy, h *valueHasher) []JsonPatchOperation {
+	patch := []JsonPatchOperation{}
+	if strategy == PatchStrategyEnsureAbsent {
+		return patch
+	}
+	common := commonSubsequence(av, bv, h.collections.lookup(p).elements(), h)
+
+	if strategy == PatchStrategyExactMatch {
+		next := len(common) - 1
+		for i := len(av) - 1; i >= 0; i-- {
+			if next >= 0 && common[next][0] == i {
+				next--
 				continue
 			}
-
-			jsonStr := string(jsonBytes)
-			if bvCounts[jsonStr] > bvSeen[jsonStr] {
-				foundIndexes[i] = struct{}{}
-				bvSeen[jsonStr]++
+			patch = append(patch, NewPatch("remove", makePath(p, i), nil))
+		}
+		next = 0
+		for j, v := range bv {
+			if next < len(common) && common[next][1] == j {
+				next++
+				continue
 			}
+			patch = append(patch, NewPatch("add", makePath(p, j), v))
 		}
+		return patch
+	}
 
-		for i, v := range av {
-			if _, ok := foundIndexes[i]; !ok {
-				applyOp(i+offset, v)
+	next, inserted := 0, 0
+	for j, v := range bv {
+		if next < len(common) && common[next][1] == j {
+			next++
+			continue
+		}
+		at := len(av)
+		if next < len(common) {
+			at = common[next][0]
+		}
+		patch = append(patch, NewPatch("add", makePath(p, at+inserted), v))
+		inserted++
+	}
+	return patch
+}
+
+// commonSubsequence returns the index pairs of the elements of a longest common subsequence of `av` and `bv`, in
+// ascending order, using Myers' diff algorithm. Equal elements at the start and the end are matched up front, so
+// arrays that only change in one place are cheap to compare.
+func commonSubsequence(av, bv []any, elementPath *pathNode, h *valueHasher) [][2]int {
+	equal := func(i, j int) bool { return h.equal(av[i], bv[j], elementPath) }
+	prefix := 0
+	for prefix < len(av) && prefix < len(bv) && equal(prefix, prefix) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(av)-prefix && suffix < len(bv)-prefix && equal(len(av)-1-suffix, len(bv)-1-suffix) {
+		suffix++
+	}
+
+	common := make([][2]int, 0, min(len(av), len(bv)))
+	for i := range prefix {
+		common = append(common, [2]int{i, i})
+	}
+	n, m := len(av)-prefix-suffix, len(bv)-prefix-suffix
+	middle := func(x, y int) bool { return equal(prefix+x, prefix+y) }
+
+	// trace[d][k+d] is the furthest x reached on diagonal k = x - y with d edits.
+	var trace [][]int
+	furthest := func(d, k int) int {
+		if k < -d || k > d {
+			return -1
+		}
+		return trace[d][k+d]
+	}
+search:
+	for d := 0; d <= n+m; d++ {
+		v := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			switch {
+			case d == 0:
+				x = 0
+			case k == -d || k != d && furthest(d-1, k-1) < furthest(d-1, k+1):
+				x = furthest(d-1, k+1)
+			default:
+				x = furthest(d-1, k-1) + 1
+			}
+			y := x - k
+			for x < n && y < m && middle(x, y) {
+				x, y = x+1, y+1
+			}
+			v[k+d] = x
+			if x >= n && y >= m {
+				trace = append(trace, v)
+				break search
 			}
 		}
-		return
-	case PatchStrategyEnsureAbsent:
-		return
+		trace = append(trace, v)
+	}
+
+	var matched [][2]int
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		previousK := k - 1
+		if k == -d || k != d && furthest(d-1, k-1) < furthest(d-1, k+1) {
+			previousK = k + 1
+		}
+		previousX := furthest(d-1, previousK)
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			x, y = x-1, y-1
+			matched = append(matched, [2]int{prefix + x, prefix + y})
+		}
+		x, y = previousX, previousY
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		matched = append(matched, [2]int{prefix + x, prefix + y})
+	}
+	slices.Reverse(matched)
+	common = append(common, matched...)
+
+	for i := range suffix {
+		common = append(common, [2]int{len(av) - suffix + i, len(bv) - suffix + i})
 	}
+	return common
 }
 
 func removeIgnoredFields(data any, ignoredFields []Path) (any, error) {
@@ -618,17 +837,21 @@ func removeJSONPath(jsonStr, jsonPath string) (string, error) {
 	return result, nil
 }
 
+// removeFromArrayElements removes the property after the first `[*]` in jsonPath from every element of the array
+// before it. Any further `[*]` is handled by recursing into the elements.
 func removeFromArrayElements(jsonStr, jsonPath string) (string, error) {
-	parts := strings.Split(jsonPath, "[*].")
-	if len(parts) != 2 {
+	arrayJsonPath, propertyToRemove, _ := strings.Cut(jsonPath, "[*]")
+	if !strings.HasPrefix(propertyToRemove, ".") {
 		return "", fmt.Errorf("invalid wildcard path format")
 	}
 
-	arrayPath := strings.TrimPrefix(parts[0], "$.")
+	arrayPath := strings.TrimPrefix(arrayJsonPath, "$.")
 	arrayPath = strings.TrimPrefix(arrayPath, "$")
-	propertyToRemove := parts[1]
 
-	arrayResult := gjson.Get(jsonStr, arrayPath)
+	arrayResult := gjson.Parse(jsonStr)
+	if arrayPath != "" {
+		arrayResult = gjson.Get(jsonStr, arrayPath)
+	}
 	if !arrayResult.Exists() || !arrayResult.IsArray() {
 		return jsonStr, nil
 	}
@@ -637,8 +860,11 @@ func removeFromArrayElements(jsonStr, jsonPath string) (string, error) {
 	var err error
 
 	arrayResult.ForEach(func(key, value gjson.Result) bool {
-		elementPath := fmt.Sprintf("%s.%d.%s", arrayPath, key.Int(), propertyToRemove)
-		result, err = sjson.Delete(result, elementPath)
+		elementPath := fmt.Sprintf("$.%s.%d%s", arrayPath, key.Int(), propertyToRemove)
+		if arrayPath == "" {
+			elementPath = fmt.Sprintf("$.%d%s", key.Int(), propertyToRemove)
+		}
+		result, err = removeJSONPath(result, elementPath)
 		return err == nil
 	})
 
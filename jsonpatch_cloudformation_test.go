@@ -0,0 +1,91 @@
+package jsonpatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var bucketProviderSchema = `{
+  "typeName": "AWS::S3::Bucket",
+  "definitions": {
+    "Tag": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {"Key": {"type": "string"}, "Value": {"type": "string"}},
+      "required": ["Value", "Key"]
+    },
+    "Rule": {
+      "type": "object",
+      "properties": {
+        "Id": {"type": "string"},
+        "Transitions": {"type": "array", "uniqueItems": true, "insertionOrder": true, "items": {"$ref": "#/definitions/Transition"}}
+      }
+    },
+    "Transition": {"type": "object", "properties": {"StorageClass": {"type": "string"}, "TransitionInDays": {"type": "integer"}}}
+  },
+  "properties": {
+    "Arn": {"type": "string"},
+    "BucketName": {"type": "string"},
+    "DomainName": {"type": "string"},
+    "Tags": {"type": "array", "insertionOrder": false, "uniqueItems": true, "items": {"$ref": "#/definitions/Tag"}},
+    "Rules": {"type": "array", "uniqueItems": true, "x-identity": "Id", "items": {"$ref": "#/definitions/Rule"}},
+    "Ports": {"type": "array", "items": {"type": "integer"}},
+    "AccessControl": {"type": "string"}
+  },
+  "createOnlyProperties": ["/properties/BucketName", "/properties/Rules/*/Id"],
+  "readOnlyProperties": ["/properties/Arn", "/properties/DomainName", "/properties/Rules/*/Transitions/*/TransitionInDays"],
+  "writeOnlyProperties": ["/properties/AccessControl"],
+  "primaryIdentifier": ["/properties/BucketName"]
+}`
+
+func TestParseCloudFormationSchema_DerivesCollectionsAndCreateOnlyPaths(t *testing.T) {
+	schema, err := ParseCloudFormationSchema([]byte(bucketProviderSchema))
+	assert.NoError(t, err)
+	assert.Equal(t, "AWS::S3::Bucket", schema.TypeName)
+	assert.Equal(t, EntitySets{"$.Rules": "Id"}, schema.Collections.EntitySets)
+	// Tags have no insertion order, so they are a set and not one of the arrays.
+	assert.Equal(t, []Path{"$.Ports", "$.Rules[*].Transitions"}, schema.Collections.Arrays)
+	assert.Equal(t, []Path{"$.Arn", "$.DomainName", "$.Rules[*].Transitions[*].TransitionInDays"}, schema.Collections.IgnoredFields)
+	assert.Equal(t, []Path{"$.BucketName", "$.Rules[*].Id"}, schema.CreateOnlyPaths)
+	assert.Equal(t, schema.CreateOnlyPaths, schema.Collections.ImmutableFields)
+	assert.Equal(t, []Path{"$.AccessControl"}, schema.WriteOnlyPaths)
+	assert.Equal(t, []Path{"$.BucketName"}, schema.PrimaryIdentifier)
+}
+
+func TestParseCloudFormationSchema_InvalidPointers_ReturnError(t *testing.T) {
+	invalid := []string{
+		`{`,
+		`{"readOnlyProperties": ["Arn"]}`,
+		`{"createOnlyProperties": ["/properties/"]}`,
+		`{"createOnlyProperties": ["/properties/A/b.c"]}`,
+		`{"primaryIdentifier": "/properties/Id"}`,
+	}
+	for _, schema := range invalid {
+		_, err := ParseCloudFormationSchema([]byte(schema))
+		assert.Error(t, err, schema)
+	}
+}
+
+func TestLoadCloudFormationSchema_ReadsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aws-s3-bucket.json")
+	assert.NoError(t, os.WriteFile(file, []byte(bucketProviderSchema), 0o600))
+	schema, err := LoadCloudFormationSchema(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "AWS::S3::Bucket", schema.TypeName)
+
+	_, err = LoadCloudFormationSchema(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestCreatePatch_WithCloudFormationCollections_IgnoresTagOrder(t *testing.T) {
+	schema, err := ParseCloudFormationSchema([]byte(bucketProviderSchema))
+	assert.NoError(t, err)
+	a := `{"Arn":"arn:1","Tags":[{"Key":"a","Value":"1"},{"Key":"b","Value":"2"}],"Ports":[1,2]}`
+	b := `{"Tags":[{"Key":"b","Value":"2"},{"Key":"a","Value":"1"}],"Ports":[2,1]}`
+	patch, err := CreatePatch([]byte(a), []byte(b), schema.Collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/Ports/0", float64(2)), NewPatch("replace", "/Ports/1", float64(1))}, patch)
+}
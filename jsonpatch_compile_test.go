@@ -0,0 +1,112 @@
+package jsonpatch
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCompile(tb testing.TB, collections Collections) *CompiledCollections {
+	compiled, err := collections.Compile()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return compiled
+}
+
+func TestCompile_MatchesArraysAndEntitySets(t *testing.T) {
+	compiled := mustCompile(t, Collections{
+		EntitySets: EntitySets{
+			Path("$.t"):      Key("k"),
+			Path("$.t[*].v"): Key("nk"),
+		},
+		Arrays: []Path{"$", "$.a.b", "$.attributes.attribute-key"},
+	})
+	assert.True(t, compiled.isArray(""))
+	assert.True(t, compiled.isArray("/"))
+	assert.True(t, compiled.isArray("/a/b"))
+	assert.True(t, compiled.isArray("/attributes/attribute-key"))
+	assert.False(t, compiled.isArray("/a"))
+	assert.False(t, compiled.isArray("/a/b/c"))
+	assert.False(t, compiled.isArray("/t"))
+
+	assert.True(t, compiled.isEntitySet("/t"))
+	assert.True(t, compiled.isEntitySet("/t/3/v"))
+	assert.False(t, compiled.isEntitySet("/t/3"))
+	assert.False(t, compiled.isEntitySet("/t/x/v"))
+
+	key, ok := compiled.entitySetKey("/t/12/v")
+	assert.True(t, ok)
+	assert.Equal(t, Key("nk"), key)
+	_, ok = compiled.entitySetKey("/a/b")
+	assert.False(t, ok)
+}
+
+func TestCompile_DecodesJsonPointerEscapes(t *testing.T) {
+	compiled := mustCompile(t, Collections{Arrays: []Path{"$.a/b.c~d"}})
+	assert.True(t, compiled.isArray(makePath(makePath("", "a/b"), "c~d")))
+}
+
+func TestCompile_RejectsInvalidPaths(t *testing.T) {
+	invalid := []Collections{
+		{Arrays: []Path{"a.b"}},
+		{Arrays: []Path{"$a"}},
+		{Arrays: []Path{"$.a..b"}},
+		{Arrays: []Path{"$.a[0]"}},
+		{Arrays: []Path{"$.a."}},
+		{EntitySets: EntitySets{"$.a[": "k"}},
+		{EntitySets: EntitySets{"$.a": ""}},
+		{IgnoredFields: []Path{"$.a[*]"}},
+		{IgnoredFields: []Path{"$.a[*][*].c"}},
+		{IgnoredFields: []Path{"$"}},
+	}
+	for _, collections := range invalid {
+		_, err := collections.Compile()
+		assert.Error(t, err, "%+v should not compile", collections)
+	}
+}
+
+func TestCompile_RejectsPathThatIsBothArrayAndEntitySet(t *testing.T) {
+	_, err := Collections{
+		EntitySets: EntitySets{Path("$.t"): Key("k")},
+		Arrays:     []Path{"$.t"},
+	}.Compile()
+	assert.ErrorContains(t, err, "both an array and an entity set")
+}
+
+func TestCreatePatch_WithInvalidCollections_ReturnsError(t *testing.T) {
+	_, err := CreatePatch([]byte(simpleObjEntitySet), []byte(simpleObjAddEntitySetItem), Collections{Arrays: []Path{"t"}}, PatchStrategyExactMatch)
+	assert.Error(t, err)
+}
+
+func TestCompiledCollections_IsImmutable(t *testing.T) {
+	collections := Collections{
+		EntitySets: EntitySets{Path("$.t"): Key("k")},
+		Arrays:     []Path{"$.a"},
+	}
+	compiled := mustCompile(t, collections)
+	collections.EntitySets[Path("$.t")] = Key("other")
+	collections.Arrays[0] = "$.b"
+	assert.Equal(t, Key("k"), compiled.Collections().EntitySets[Path("$.t")])
+	assert.Equal(t, []Path{"$.a"}, compiled.Collections().Arrays)
+	assert.True(t, compiled.isArray("/a"))
+}
+
+func TestCompiledCollections_CreatePatch_IsSafeForConcurrentUse(t *testing.T) {
+	compiled := mustCompile(t, entitySetTestCollections)
+	expected, err := CreatePatch([]byte(complexNextedEntitySet), []byte(complexNextedEntitySetModifyItem), entitySetTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			patch, err := compiled.CreatePatch([]byte(complexNextedEntitySet), []byte(complexNextedEntitySetModifyItem), PatchStrategyExactMatch)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, patch, "they should be equal")
+		}()
+	}
+	wg.Wait()
+}
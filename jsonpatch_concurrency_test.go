@@ -0,0 +1,96 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var concurrencyTestCollections = Collections{
+	EntitySets: EntitySets{
+		Path("$.resources"):            Key("id"),
+		Path("$.resources[*].members"): Key("name"),
+	},
+	Arrays: []Path{"$.resources[*].ports"},
+}
+
+func largeDocument(n int, modified bool) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"resources":[`)
+	for i := range n {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		v := i
+		if modified && i%3 == 0 {
+			v = i + 1
+		}
+		fmt.Fprintf(&sb, `{"id":%d,"version":%d,"ports":[%d,%d,%d],"tags":["t%d","u%d"],"members":[{"name":"m%d","role":"r%d"},{"name":"n%d","role":"r%d"}]}`,
+			i, v, v, i, v, v, i, i, v, i, i)
+	}
+	sb.WriteString(`]`)
+	for i := range n {
+		v := i
+		if modified && i%5 == 0 {
+			v = -i
+		}
+		fmt.Fprintf(&sb, `,"entity%d":{"name":"e%d","size":%d,"labels":{"a":"%d","b":"x"},"items":[%d,%d]}`, i, i, v, v, i, v)
+	}
+	sb.WriteString(`}`)
+	return []byte(sb.String())
+}
+
+func TestCreatePatch_WithConcurrency_MatchesSequentialPatch(t *testing.T) {
+	a := largeDocument(300, false)
+	b := largeDocument(300, true)
+	for _, strategy := range []PatchStrategy{PatchStrategyExactMatch, PatchStrategyEnsureExists} {
+		expected, err := CreatePatch(a, b, concurrencyTestCollections, strategy)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, expected)
+		for range 5 {
+			patch, err := CreatePatch(a, b, concurrencyTestCollections, strategy, WithConcurrency(8))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, patch, "they should be equal")
+		}
+	}
+}
+
+func TestCreatePatch_WithConcurrency_MatchesSequentialPatchForEntitySets(t *testing.T) {
+	for _, strategy := range []PatchStrategy{PatchStrategyExactMatch, PatchStrategyEnsureExists} {
+		expected, err := CreatePatch([]byte(complexNextedEntitySet), []byte(complexNextedEntitySetModifyItem), entitySetTestCollections, strategy)
+		assert.NoError(t, err)
+		patch, err := CreatePatch([]byte(complexNextedEntitySet), []byte(complexNextedEntitySetModifyItem), entitySetTestCollections, strategy, WithConcurrency(4))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, patch, "they should be equal")
+	}
+}
+
+func TestWorkerPool_RunsEveryTaskOnce(t *testing.T) {
+	pool := newWorkerPool(3)
+	counts := make([]int, 100)
+	pool.run(len(counts), func(i int) {
+		// Nested runs must not deadlock when all workers are busy.
+		pool.run(2, func(int) {})
+		counts[i]++
+	})
+	for i, c := range counts {
+		assert.Equal(t, 1, c, "task %d should run once", i)
+	}
+}
+
+func BenchmarkLargeDocument(b *testing.B) {
+	a1 := largeDocument(1000, false)
+	a2 := largeDocument(1000, true)
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = CreatePatch(a1, a2, concurrencyTestCollections, PatchStrategyExactMatch)
+		}
+	})
+	b.Run("concurrent", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = CreatePatch(a1, a2, concurrencyTestCollections, PatchStrategyExactMatch, WithConcurrency(8))
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package jsonpatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var configYaml = `version: 1
+resources:
+  AWS::S3::Bucket:
+    strategy: ensure-exists
+    entitySets:
+      $.t: k
+      $.t[*].v: nk
+    arrays:
+      - $.persons
+    ignoredFields:
+      - $.b[*].d
+    includedFields:
+      - $.t
+    immutableFields:
+      - $.name
+    atomicFields:
+      - $.args
+  AWS::IAM::Role: {}
+`
+
+var configJson = `{
+  "version": 1,
+  "resources": {
+    "AWS::S3::Bucket": {"strategy": "exact-match", "arrays": ["$.persons"]}
+  }
+}`
+
+func TestParseConfig_ReadsResources(t *testing.T) {
+	config, err := ParseConfig([]byte(configYaml))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, config.Version)
+
+	bucket, ok := config.Resource("AWS::S3::Bucket")
+	assert.True(t, ok)
+	assert.Equal(t, PatchStrategyEnsureExists, bucket.Strategy)
+	assert.Equal(t, EntitySets{"$.t": "k", "$.t[*].v": "nk"}, bucket.Collections.EntitySets)
+	assert.Equal(t, []Path{"$.name"}, bucket.Collections.ImmutableFields)
+	assert.Equal(t, []Path{"$.args"}, bucket.Collections.AtomicFields)
+	assert.Equal(t, []Path{"$.persons"}, bucket.Collections.Arrays)
+	assert.Equal(t, []Path{"$.b[*].d"}, bucket.Collections.IgnoredFields)
+	assert.Equal(t, []Path{"$.t"}, bucket.Collections.IncludedFields)
+
+	role, ok := config.Resource("AWS::IAM::Role")
+	assert.True(t, ok)
+	assert.Equal(t, PatchStrategyExactMatch, role.Strategy)
+
+	_, ok = config.Resource("AWS::EC2::Instance")
+	assert.False(t, ok)
+}
+
+func TestParseConfig_ReadsJson(t *testing.T) {
+	config, err := ParseConfig([]byte(configJson))
+	assert.NoError(t, err)
+	bucket, ok := config.Resource("AWS::S3::Bucket")
+	assert.True(t, ok)
+	assert.Equal(t, []Path{"$.persons"}, bucket.Collections.Arrays)
+}
+
+func TestParseConfig_ConfigCanBeUsedToCreatePatch(t *testing.T) {
+	config, err := ParseConfig([]byte(configYaml))
+	assert.NoError(t, err)
+	bucket, _ := config.Resource("AWS::S3::Bucket")
+	patch, err := CreatePatch([]byte(simpleObjEntitySet), []byte(simpleObjModifyEntitySetItem), bucket.Collections, bucket.Strategy)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/t/1/v", float64(3))}, patch)
+}
+
+func TestParseConfig_ErrorsPointAtOffendingLine(t *testing.T) {
+	tests := []struct {
+		config string
+		line   int
+		column int
+		msg    string
+	}{
+		{"resources: {}\n", 1, 1, "missing version"},
+		{"version: 2\nresources: {}\n", 1, 10, "unsupported version"},
+		{"version: 1\nresource: {}\n", 2, 1, `unknown field "resource"`},
+		{"version: 1\nresources:\n  A:\n    strategy: merge\n", 4, 15, `unknown strategy "merge"`},
+		{"version: 1\nresources:\n  A:\n    arrays:\n      - $.a\n      - a.b\n", 6, 9, `invalid path "a.b"`},
+		{"version: 1\nresources:\n  A:\n    entitySets:\n      $.t: \"\"\n", 5, 12, "must have a key"},
+		{"version: 1\nresources:\n  A:\n    entitySets:\n      $.t: k\n    arrays:\n      - $.t\n", 7, 9, "both an array and an entity set"},
+		{"version: 1\nresources:\n  A:\n    ignoredFields:\n      - $.a[*]\n", 5, 9, "invalid ignored field"},
+		{"version: 1\nresources:\n  A: {}\n  A: {}\n", 4, 3, `duplicate key "A"`},
+		{"version: 1\nresources:\n  A:\n    arrays: $.a\n", 4, 13, "arrays must be a list"},
+		{"version: 1\nresources: [\n", 2, 1, "yaml:"},
+	}
+	for _, test := range tests {
+		_, err := ParseConfig([]byte(test.config))
+		var configErr *ConfigError
+		if assert.ErrorAs(t, err, &configErr, test.config) {
+			assert.Equal(t, test.line, configErr.Line, test.config)
+			if test.column > 1 {
+				assert.Equal(t, test.column, configErr.Column, test.config)
+			}
+			assert.Contains(t, configErr.Msg, test.msg, test.config)
+		}
+	}
+}
+
+func TestLoadConfig_ErrorsIncludeFileName(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("version: 1\nresources:\n  A:\n    strategy: merge\n"), 0o600))
+	_, err := LoadConfig(file)
+	assert.EqualError(t, err, file+":4:15: unknown strategy \"merge\"")
+}
+
+func TestConfig_MarshalRoundTrips(t *testing.T) {
+	config, err := ParseConfig([]byte(configYaml))
+	assert.NoError(t, err)
+	data, err := config.Marshal()
+	assert.NoError(t, err)
+	roundTripped, err := ParseConfig(data)
+	assert.NoError(t, err)
+	assert.Equal(t, config, roundTripped)
+}
+
+func TestInference_ExportsConfig(t *testing.T) {
+	inference, err := InferCollections([]byte(inferSample1), []byte(inferSample2))
+	assert.NoError(t, err)
+	data, err := inference.Config("AWS::S3::Bucket", PatchStrategyExactMatch, 0.6).Marshal()
+	assert.NoError(t, err)
+	config, err := ParseConfig(data)
+	assert.NoError(t, err)
+	bucket, ok := config.Resource("AWS::S3::Bucket")
+	assert.True(t, ok)
+	assert.Equal(t, EntitySets{"$.rules": "id", "$.tags": "key"}, bucket.Collections.EntitySets)
+}
@@ -0,0 +1,138 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var defaultsTestCollections = Collections{
+	Defaults: map[Path]any{
+		"$.versioning":        "Disabled",
+		"$.timeout":           30,
+		"$.rules[*].priority": 0,
+	},
+}
+
+func TestCreatePatch_FieldsHoldingTheirDefault_AreMissing(t *testing.T) {
+	a := `{"name":"x","versioning":"Disabled","timeout":30}`
+	b := `{"name":"x","versioning":"Disabled"}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), defaultsTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+
+	equal, err := Equal([]byte(a), []byte(`{"name":"x"}`), defaultsTestCollections)
+	assert.NoError(t, err)
+	assert.True(t, equal)
+}
+
+func TestCreatePatch_FieldsDifferentFromTheirDefault_AreDiffed(t *testing.T) {
+	a := `{"versioning":"Disabled","timeout":30}`
+	b := `{"versioning":"Enabled","timeout":60}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), defaultsTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("replace", "/timeout", float64(60)),
+		NewPatch("replace", "/versioning", "Enabled"),
+	}, patch)
+
+	drift, reason, err := HasDrift([]byte(a), []byte(b), defaultsTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, DriftChanged, reason.Kind)
+}
+
+func TestCreatePatch_DesiredFieldsHoldingTheirDefault_AreDiffed(t *testing.T) {
+	tests := []struct {
+		actual, desired string
+		patch           []JsonPatchOperation
+	}{
+		{`{"versioning":"Enabled"}`, `{"versioning":"Disabled"}`, []JsonPatchOperation{NewPatch("replace", "/versioning", "Disabled")}},
+		{`{"timeout":60}`, `{"timeout":30}`, []JsonPatchOperation{NewPatch("replace", "/timeout", float64(30))}},
+		{`{}`, `{"timeout":30}`, []JsonPatchOperation{NewPatch("add", "/timeout", float64(30))}},
+		{`{"timeout":30}`, `{"timeout":30}`, []JsonPatchOperation{}},
+	}
+	for _, tt := range tests {
+		patch, err := CreatePatch([]byte(tt.actual), []byte(tt.desired), defaultsTestCollections, PatchStrategyExactMatch)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.patch, patch, tt.desired)
+	}
+
+	drift, reason, err := HasDrift([]byte(`{"versioning":"Enabled"}`), []byte(`{"versioning":"Disabled"}`), defaultsTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, &DriftReason{Path: "/versioning", Kind: DriftChanged, Desired: "Disabled"}, reason)
+}
+
+func TestCreatePatch_DefaultsInSetsOfObjects(t *testing.T) {
+	a := `{"rules":[{"name":"a","priority":0},{"name":"b","priority":1}]}`
+	b := `{"rules":[{"name":"b","priority":1},{"name":"a"}]}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), defaultsTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+}
+
+func TestCreatePatch_DesiredDefaultsInEntitySets_AreDiffed(t *testing.T) {
+	collections := defaultsTestCollections
+	collections.EntitySets = EntitySets{"$.rules": "name"}
+	a := `{"rules":[{"name":"a","priority":1},{"name":"b","priority":0}]}`
+	b := `{"rules":[{"name":"b"},{"name":"a","priority":0}]}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/rules/0/priority", float64(0))}, patch)
+}
+
+func TestCompile_InvalidDefaults_ReturnError(t *testing.T) {
+	for _, path := range []Path{"$", "$.a[*]", "a"} {
+		_, err := Collections{Defaults: map[Path]any{path: 1}}.Compile()
+		assert.Error(t, err, path)
+	}
+	_, err := Collections{Defaults: map[Path]any{"$.a": func() {}}}.Compile()
+	assert.Error(t, err)
+}
+
+func TestCollectionsFromJsonSchema_ReadsDefaults(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"versioning": {"type": "string", "default": "Disabled"},
+			"timeout": {"type": "integer", "default": 30},
+			"rules": {"type": "array", "items": {"type": "object", "properties": {"priority": {"default": 0}}}}
+		}
+	}`
+
+	collections, err := CollectionsFromJsonSchema([]byte(schema))
+	assert.NoError(t, err)
+	assert.Equal(t, map[Path]any{
+		"$.versioning":        "Disabled",
+		"$.timeout":           float64(30),
+		"$.rules[*].priority": float64(0),
+	}, collections.Defaults)
+
+	patch, err := CreatePatch([]byte(`{"timeout":30,"versioning":"Disabled"}`), []byte(`{}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+}
+
+func TestParseConfig_ReadsDefaults(t *testing.T) {
+	config, err := ParseConfig([]byte(`version: 1
+resources:
+  R:
+    defaults:
+      $.timeout: 30
+      $.versioning: Disabled
+`))
+	assert.NoError(t, err)
+	r, _ := config.Resource("R")
+	assert.Equal(t, map[Path]any{"$.timeout": 30, "$.versioning": "Disabled"}, r.Collections.Defaults)
+
+	data, err := config.Marshal()
+	assert.NoError(t, err)
+	roundTripped, err := ParseConfig(data)
+	assert.NoError(t, err)
+	assert.Equal(t, config, roundTripped)
+}
@@ -0,0 +1,92 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasDrift_EqualDocuments_HaveNoDrift(t *testing.T) {
+	a := `{"a":1,"s":[1,2,3],"t":[{"k":1,"v":1},{"k":2,"v":2}]}`
+	b := `{"a":1,"s":[3,2,1],"t":[{"k":2,"v":2},{"k":1,"v":1}]}`
+
+	drift, reason, err := HasDrift([]byte(a), []byte(b), entitySetTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.False(t, drift)
+	assert.Nil(t, reason)
+}
+
+func TestHasDrift_ReportsTheFirstDifference(t *testing.T) {
+	a := `{"a":1,"b":{"c":1},"d":1}`
+	b := `{"a":1,"b":{"c":2},"d":2,"e":3}`
+
+	drift, reason, err := HasDrift([]byte(a), []byte(b), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, &DriftReason{Path: "/b/c", Kind: DriftChanged, Desired: float64(2)}, reason)
+}
+
+func TestHasDrift_MissingAndUnexpectedValues(t *testing.T) {
+	drift, reason, err := HasDrift([]byte(`{}`), []byte(`{"a":1}`), Collections{}, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, &DriftReason{Path: "/a", Kind: DriftMissing, Desired: float64(1)}, reason)
+
+	drift, reason, err = HasDrift([]byte(`{"s":[1,2]}`), []byte(`{"s":[1]}`), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, &DriftReason{Path: "/s/1", Kind: DriftUnexpected}, reason)
+}
+
+func TestHasDrift_FollowsTheStrategy(t *testing.T) {
+	a := `{"s":[1,2]}`
+	b := `{"s":[1]}`
+
+	drift, _, err := HasDrift([]byte(a), []byte(b), Collections{}, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.False(t, drift)
+}
+
+func TestHasDrift_IgnoresIgnoredFields(t *testing.T) {
+	a := `{"a":1,"status":"running"}`
+	b := `{"a":1,"status":"stopped"}`
+
+	drift, _, err := HasDrift([]byte(a), []byte(b), Collections{IgnoredFields: []Path{"$.status"}}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.False(t, drift)
+}
+
+func TestHasDrift_InEntitySet(t *testing.T) {
+	b := `{"t":[{"k":1,"v":1},{"k":2,"v":5},{"k":3,"v":3}]}`
+
+	drift, reason, err := HasDrift([]byte(simpleObjEntitySet), []byte(b), entitySetTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, &DriftReason{Path: "/t/1/v", Kind: DriftChanged, Desired: float64(5)}, reason)
+}
+
+func TestHasDrift_ReportsMismatches(t *testing.T) {
+	a := `{"arn":"arn:aws:s3:::bucket"}`
+	b := `{"arn":{"$match":"^arn:aws:iam::"}}`
+
+	drift, reason, err := HasDrift([]byte(a), []byte(b), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, &DriftReason{Path: "/arn", Kind: DriftMismatch, Desired: map[string]any{"$match": "^arn:aws:iam::"}}, reason)
+}
+
+func TestHasDrift_DeletedMissingMember_IsNoDrift(t *testing.T) {
+	drift, _, err := HasDrift([]byte(`{"a":1}`), []byte(`{"a":1,"b":{"$delete":true}}`), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.False(t, drift)
+}
+
+func TestHasDrift_ExtraMemberInEntity_DoesNotHideLaterDrift(t *testing.T) {
+	a := `{"t":[{"k":1,"v":1,"x":1}],"z":1}`
+	b := `{"t":[{"k":1,"v":1}],"z":2}`
+
+	drift, reason, err := HasDrift([]byte(a), []byte(b), entitySetTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.True(t, drift)
+	assert.Equal(t, &DriftReason{Path: "/z", Kind: DriftChanged, Desired: float64(2)}, reason)
+}
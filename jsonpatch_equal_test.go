@@ -0,0 +1,74 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEqual_SetsAndEntitySets_AreOrderInsensitive(t *testing.T) {
+	a := `{"a":100,"s":["x","y"],"t":[{"k":1,"v":1},{"k":2,"v":2}]}`
+	b := `{"t":[{"v":2,"k":2},{"k":1,"v":1}],"s":["y","x"],"a":100}`
+
+	equal, err := Equal([]byte(a), []byte(b), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.True(t, equal)
+
+	ha, err := Hash([]byte(a), entitySetTestCollections)
+	assert.NoError(t, err)
+	hb, err := Hash([]byte(b), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.Equal(t, ha, hb)
+}
+
+func TestEqual_ArraysAreOrderSensitive(t *testing.T) {
+	a := `{"l":[1,2]}`
+	b := `{"l":[2,1]}`
+	collections := Collections{Arrays: []Path{"$.l"}}
+
+	equal, err := Equal([]byte(a), []byte(b), collections)
+	assert.NoError(t, err)
+	assert.False(t, equal)
+
+	ha, err := Hash([]byte(a), collections)
+	assert.NoError(t, err)
+	hb, err := Hash([]byte(b), collections)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ha, hb)
+}
+
+func TestEqual_IgnoredFieldsAreLeftOut(t *testing.T) {
+	a := `{"a":1,"status":{"phase":"running"}}`
+	b := `{"a":1}`
+	collections := Collections{IgnoredFields: []Path{"$.status"}}
+
+	equal, err := Equal([]byte(a), []byte(b), collections)
+	assert.NoError(t, err)
+	assert.True(t, equal)
+
+	ha, err := Hash([]byte(a), collections)
+	assert.NoError(t, err)
+	hb, err := Hash([]byte(b), collections)
+	assert.NoError(t, err)
+	assert.Equal(t, ha, hb)
+}
+
+func TestEqual_DifferentEntities_AreNotEqual(t *testing.T) {
+	b := `{"a":100,"t":[{"k":1,"v":1},{"k":2,"v":3}]}`
+
+	equal, err := Equal([]byte(simpleObjEntitySet), []byte(b), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.False(t, equal)
+}
+
+func TestHash_IsStable(t *testing.T) {
+	// The hash is meant to be stored, it must not change between runs or releases.
+	h, err := Hash([]byte(`{"a":[1,"x",true,null],"b":{"c":1.5}}`), Collections{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x61feef1a2c8bfd76), h)
+}
+
+func TestHash_InvalidDocument_ReturnsError(t *testing.T) {
+	_, err := Hash([]byte(`{`), Collections{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePatch_WithoutEquivalences_NullAndEmptyDiffer(t *testing.T) {
+	patch, err := CreatePatch([]byte(`{"a":null}`), []byte(`{"a":[]}`), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/a", []any{})}, patch)
+}
+
+func TestCreatePatch_NullAndEmptyAreMissing_Globally(t *testing.T) {
+	a := `{"a":null,"b":[],"c":{"d":null},"e":1}`
+	b := `{"a":{},"b":null,"c":{},"f":[],"g":{"h":null},"e":1}`
+	collections := Collections{Equivalences: map[Path]Equivalence{"$": NullIsMissing | EmptyIsMissing}}
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+
+	equal, err := Equal([]byte(a), []byte(`{"e":1}`), collections)
+	assert.NoError(t, err)
+	assert.True(t, equal)
+}
+
+func TestCreatePatch_NullIsMissing_StillAddsValues(t *testing.T) {
+	collections := Collections{Equivalences: map[Path]Equivalence{"$": NullIsMissing}}
+
+	patch, err := CreatePatch([]byte(`{"a":null}`), []byte(`{"a":"x","b":[]}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("replace", "/a", "x"),
+		NewPatch("add", "/b", []any{}),
+	}, patch)
+}
+
+func TestCreatePatch_EquivalentDesiredValues_AreNotManaged(t *testing.T) {
+	collections := Collections{Equivalences: map[Path]Equivalence{"$": NullIsMissing | EmptyIsMissing | DefaultIsMissing}}
+	tests := []struct{ actual, desired string }{
+		{`{"enabled":true}`, `{"enabled":false}`},
+		{`{"tags":["a"]}`, `{"tags":[]}`},
+		{`{"spec":{"name":"x"}}`, `{"spec":null}`},
+		{`{"spec":{"replicas":3}}`, `{"spec":{"replicas":0,"paused":false}}`},
+	}
+	for _, tt := range tests {
+		patch, err := CreatePatch([]byte(tt.actual), []byte(tt.desired), collections, PatchStrategyExactMatch)
+		assert.NoError(t, err)
+		assert.Equal(t, []JsonPatchOperation{}, patch, tt.desired)
+
+		// The desired document is equal to one without the member, so both give the same patch.
+		equal, err := Equal([]byte(tt.desired), []byte(`{}`), collections)
+		assert.NoError(t, err)
+		assert.True(t, equal, tt.desired)
+		drift, _, err := HasDrift([]byte(tt.actual), []byte(tt.desired), collections, PatchStrategyExactMatch)
+		assert.NoError(t, err)
+		assert.False(t, drift, tt.desired)
+	}
+}
+
+func TestCreatePatch_EquivalentActualValues_AreReplaced(t *testing.T) {
+	collections := Collections{Equivalences: map[Path]Equivalence{"$": NullIsMissing | DefaultIsMissing}}
+
+	patch, err := CreatePatch([]byte(`{"a":null,"b":false}`), []byte(`{"a":{"c":1},"b":true}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("replace", "/a", map[string]any{"c": float64(1)}),
+		NewPatch("replace", "/b", true),
+	}, patch)
+}
+
+func TestCreatePatch_EquivalentDesiredValues_InEntitySetsAndArrays(t *testing.T) {
+	collections := Collections{
+		EntitySets:   EntitySets{"$.rules": "name"},
+		Arrays:       []Path{"$.steps"},
+		Equivalences: map[Path]Equivalence{"$": DefaultIsMissing},
+	}
+	a := `{"rules":[{"name":"a","enabled":true},{"name":"b","enabled":false}],"steps":[{"retry":true},{"retry":false}]}`
+	b := `{"rules":[{"name":"b"},{"name":"a","enabled":false}],"steps":[{"retry":false},{"retry":false,"timeout":0}]}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+}
+
+func TestJsonPatchOperation_NullValues_AreEncoded(t *testing.T) {
+	replace := NewPatch("replace", "/a", nil)
+	assert.Equal(t, `{"op":"replace","path":"/a","value":null}`, replace.Json())
+	add := NewPatch("add", "/a", nil)
+	assert.Equal(t, `{"op":"add","path":"/a","value":null}`, add.Json())
+	remove := NewPatch("remove", "/a", nil)
+	assert.Equal(t, `{"op":"remove","path":"/a"}`, remove.Json())
+
+	patch, err := CreatePatch([]byte(`{"a":1}`), []byte(`{"a":null}`), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	encoded, err := json.Marshal(patch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"replace","path":"/a","value":null}]`, string(encoded))
+}
+
+func TestCompile_InvalidEquivalence_ReturnsError(t *testing.T) {
+	_, err := Collections{Equivalences: map[Path]Equivalence{"$.a": 0}}.Compile()
+	assert.Error(t, err)
+	_, err = Collections{Equivalences: map[Path]Equivalence{"$.a": 64}}.Compile()
+	assert.Error(t, err)
+}
+
+func TestParseConfig_ReadsEquivalences(t *testing.T) {
+	config, err := ParseConfig([]byte(`version: 1
+resources:
+  R:
+    equivalences:
+      $: [null, empty]
+      $.spec: [default]
+`))
+	assert.NoError(t, err)
+	r, _ := config.Resource("R")
+	assert.Equal(t, map[Path]Equivalence{"$": NullIsMissing | EmptyIsMissing, "$.spec": DefaultIsMissing}, r.Collections.Equivalences)
+
+	data, err := config.Marshal()
+	assert.NoError(t, err)
+	roundTripped, err := ParseConfig(data)
+	assert.NoError(t, err)
+	assert.Equal(t, config, roundTripped)
+
+	_, err = ParseConfig([]byte("version: 1\nresources:\n  R:\n    equivalences:\n      $: [zero]\n"))
+	assert.ErrorContains(t, err, `unknown equivalence "zero"`)
+}
@@ -0,0 +1,113 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var hashNestedSet = `{"b":[{"c":[1,2,3]},{"c":[4,5]}]}`
+var hashNestedSetReordered = `{"b":[{"c":[5,4]},{"c":[3,1,2]}]}`
+
+var hashTestCollections = Collections{
+	Arrays: []Path{"$.b[*].c"},
+}
+
+func unmarshal(t testing.TB, s string) any {
+	var v any
+	err := json.Unmarshal([]byte(s), &v)
+	assert.NoError(t, err)
+	return v
+}
+
+func TestHash_NestedSetsAreOrderInsensitive(t *testing.T) {
+	h := newValueHasher(mustCompile(t, Collections{}))
+	a := unmarshal(t, hashNestedSet)
+	b := unmarshal(t, hashNestedSetReordered)
+	assert.Equal(t, h.hash(a, h.collections.root), h.hash(b, h.collections.root), "they should be equal")
+	assert.True(t, h.equal(a, b, h.collections.root))
+}
+
+func TestHash_NestedArraysAreOrderSensitive(t *testing.T) {
+	h := newValueHasher(mustCompile(t, hashTestCollections))
+	a := unmarshal(t, hashNestedSet)
+	b := unmarshal(t, hashNestedSetReordered)
+	assert.NotEqual(t, h.hash(a, h.collections.root), h.hash(b, h.collections.root), "they should not be equal")
+	assert.False(t, h.equal(a, b, h.collections.root))
+}
+
+func TestHash_ObjectsAreKeyOrderInsensitive(t *testing.T) {
+	h := newValueHasher(mustCompile(t, Collections{}))
+	a := unmarshal(t, `{"a":1,"b":"x","c":null}`)
+	b := unmarshal(t, `{"c":null,"b":"x","a":1}`)
+	assert.Equal(t, h.hash(a, h.collections.root), h.hash(b, h.collections.root), "they should be equal")
+	assert.True(t, h.equal(a, b, h.collections.root))
+}
+
+func TestHash_SetsCountDuplicates(t *testing.T) {
+	h := newValueHasher(mustCompile(t, Collections{}))
+	a := unmarshal(t, `[1,1,2]`).([]any)
+	b := unmarshal(t, `[1,2,2]`).([]any)
+	assert.NotEqual(t, h.hash(a, h.collections.root), h.hash(b, h.collections.root), "they should not be equal")
+	assert.False(t, h.setEqual(a, b, nil))
+}
+
+func TestHash_DistinguishesTypes(t *testing.T) {
+	h := newValueHasher(mustCompile(t, Collections{}))
+	values := []any{nil, true, false, float64(0), float64(1), "", "1", map[string]any{}, []any{}}
+	seen := make(map[uint64]any)
+	for _, v := range values {
+		hv := h.hash(v, h.collections.root)
+		_, ok := seen[hv]
+		assert.False(t, ok, "hash of %v should be unique", v)
+		seen[hv] = v
+	}
+}
+
+func TestHashIndex_TakeReturnsLowestUnusedIndex(t *testing.T) {
+	h := newValueHasher(mustCompile(t, Collections{}))
+	index := h.index([]any{"a", "b", "a", "a"}, nil)
+	assert.Equal(t, 0, index.take("a"))
+	assert.Equal(t, 2, index.take("a"))
+	assert.Equal(t, 1, index.take("b"))
+	assert.Equal(t, 3, index.take("a"))
+	assert.Equal(t, -1, index.take("a"))
+	assert.Equal(t, -1, index.take("c"))
+}
+
+func TestCreatePatch_ReorderNestedSetInObjectSet_GeneratesNoOperations(t *testing.T) {
+	patch, err := CreatePatch([]byte(hashNestedSet), []byte(hashNestedSetReordered), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(patch), "they should be equal")
+}
+
+func largeObjectSet(n int, reversed bool) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"b":[`)
+	for i := range n {
+		j := i
+		if reversed {
+			j = n - 1 - i
+		}
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"id":%d,"name":"item-%d","tags":["x","y","%d"],"nested":{"a":%d,"b":[%d,%d]}}`, j, j, j, j, j, j+1)
+	}
+	sb.WriteString(`]}`)
+	return []byte(sb.String())
+}
+
+func BenchmarkLargeObjectSet(b *testing.B) {
+	a1 := unmarshal(b, string(largeObjectSet(5000, false)))
+	a2 := unmarshal(b, string(largeObjectSet(5001, true)))
+	collections := mustCompile(b, Collections{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = handleValues(a1, a2, "", []JsonPatchOperation{}, PatchStrategyExactMatch, collections, newDiffState(collections, options{}))
+	}
+}
@@ -0,0 +1,120 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var awsTagsCollections = Collections{
+	EntitySets:  EntitySets{"$.Tags": "Key"},
+	IgnoreRules: []IgnoreRule{{Path: "$.Tags[*]", Field: "Key", IfPrefix: "aws:"}},
+}
+
+func TestCreatePatch_IgnoreRule_IfEmpty(t *testing.T) {
+	collections := Collections{IgnoreRules: []IgnoreRule{{Path: "$.Description", IfEmpty: true}}}
+
+	for _, docs := range [][2]string{
+		{`{"Description":"x"}`, `{"Description":""}`},
+		{`{"Description":null}`, `{"Description":"y"}`},
+		{`{}`, `{"Description":"y"}`},
+	} {
+		patch, err := CreatePatch([]byte(docs[0]), []byte(docs[1]), collections, PatchStrategyExactMatch)
+		assert.NoError(t, err)
+		assert.Equal(t, []JsonPatchOperation{}, patch, docs)
+	}
+
+	patch, err := CreatePatch([]byte(`{"Description":"x"}`), []byte(`{"Description":"y"}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/Description", "y")}, patch)
+}
+
+func TestCreatePatch_IgnoreRule_IgnoresEntitiesByKeyPrefix(t *testing.T) {
+	a := `{"Tags":[{"Key":"aws:cloudformation:stack-name","Value":"s"},{"Key":"team","Value":"a"}]}`
+	b := `{"Tags":[{"Key":"team","Value":"b"},{"Key":"aws:other","Value":"x"}]}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), awsTagsCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/Tags/1/Value", "b")}, patch)
+}
+
+func TestCreatePatch_IgnoreRule_IfOmitted_KeepsActualSetElements(t *testing.T) {
+	a := `{"s":["x","y"]}`
+	b := `{"s":["x","z"]}`
+	collections := Collections{IgnoreRules: []IgnoreRule{{Path: "$.s[*]", IfOmitted: true}}}
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("add", "/s/2", "z")}, patch)
+}
+
+func TestCreatePatch_IgnoreRule_WithPredicate(t *testing.T) {
+	// Ignore the replica count when it is scaled up beyond the desired one.
+	collections := Collections{IgnoreRules: []IgnoreRule{{Path: "$.replicas", If: func(actual, desired any, inActual, inDesired bool) bool {
+		a, _ := actual.(float64)
+		d, _ := desired.(float64)
+		return inActual && a > d
+	}}}}
+
+	patch, err := CreatePatch([]byte(`{"replicas":5}`), []byte(`{"replicas":3}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+
+	patch, err = CreatePatch([]byte(`{"replicas":1}`), []byte(`{"replicas":3}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/replicas", float64(3))}, patch)
+}
+
+func TestCreateStrategicMergePatch_IgnoreRule_IgnoresEntitiesByKeyPrefix(t *testing.T) {
+	a := `{"Tags":[{"Key":"aws:cloudformation:stack-name","Value":"s"},{"Key":"team","Value":"a"}]}`
+	b := `{"Tags":[{"Key":"team","Value":"a"}]}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), awsTagsCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(patch))
+}
+
+func TestCompile_InvalidIgnoreRules_ReturnError(t *testing.T) {
+	for _, rule := range []IgnoreRule{
+		{Path: "$.a"},
+		{Path: "$", IfEmpty: true},
+		{Path: "$.a", Field: "k", IfEmpty: true},
+		{Path: "$.l[*]", IfOmitted: true},
+		{Path: "$.a", IfOmitted: true},
+		{Path: "$.s[*].a", IfOmitted: true},
+		{Path: "a", IfEmpty: true},
+	} {
+		_, err := Collections{Arrays: []Path{"$.l"}, IgnoreRules: []IgnoreRule{rule}}.Compile()
+		assert.Error(t, err, rule.Path)
+	}
+}
+
+func TestParseConfig_ReadsIgnoreRules(t *testing.T) {
+	config, err := ParseConfig([]byte(`version: 1
+resources:
+  AWS::S3::Bucket:
+    entitySets:
+      $.Tags: Key
+    ignoreRules:
+      - path: $.Tags[*]
+        field: Key
+        ifPrefix: "aws:"
+      - path: $.Description
+        ifEmpty: true
+`))
+	assert.NoError(t, err)
+	bucket, _ := config.Resource("AWS::S3::Bucket")
+	assert.Equal(t, []IgnoreRule{
+		{Path: "$.Tags[*]", Field: "Key", IfPrefix: "aws:"},
+		{Path: "$.Description", IfEmpty: true},
+	}, bucket.Collections.IgnoreRules)
+
+	data, err := config.Marshal()
+	assert.NoError(t, err)
+	roundTripped, err := ParseConfig(data)
+	assert.NoError(t, err)
+	assert.Equal(t, config, roundTripped)
+
+	_, err = ParseConfig([]byte("version: 1\nresources:\n  R:\n    ignoreRules:\n      - path: $.a\n"))
+	assert.ErrorContains(t, err, "no condition")
+}
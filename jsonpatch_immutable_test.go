@@ -0,0 +1,78 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var immutableTestCollections = Collections{
+	EntitySets:      EntitySets{"$.rules": "id"},
+	ImmutableFields: []Path{"$.name", "$.rules[*].id", "$.encryption"},
+}
+
+func TestCreatePlan_ChangesToImmutableFields_RequireReplacement(t *testing.T) {
+	a := `{"name":"a","size":1,"encryption":{"algorithm":"AES256"},"rules":[{"id":"r1","days":1}]}`
+	b := `{"name":"b","size":2,"encryption":{"algorithm":"aws:kms"},"rules":[{"id":"r1","days":2}]}`
+
+	plan, err := CreatePlan([]byte(a), []byte(b), immutableTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("replace", "/rules/0/days", float64(2)),
+		NewPatch("replace", "/size", float64(2)),
+	}, plan.Patch)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("replace", "/encryption/algorithm", "aws:kms"),
+		NewPatch("replace", "/name", "b"),
+	}, plan.RequiresReplacement)
+}
+
+func TestCreatePlan_AddingImmutableFields_RequiresReplacement(t *testing.T) {
+	a := `{"size":1,"encryption":{"algorithm":"AES256"}}`
+	b := `{"name":"b","size":1,"encryption":{"algorithm":"AES256","key":"k"}}`
+
+	plan, err := CreatePlan([]byte(a), []byte(b), immutableTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Patch)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("add", "/encryption/key", "k"),
+		NewPatch("add", "/name", "b"),
+	}, plan.RequiresReplacement)
+}
+
+func TestCreatePlan_WithoutImmutableFields_ReturnsWholePatch(t *testing.T) {
+	plan, err := CreatePlan([]byte(`{"name":"a"}`), []byte(`{"name":"b"}`), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/name", "b")}, plan.Patch)
+	assert.Empty(t, plan.RequiresReplacement)
+}
+
+func TestCreatePatch_LeavesOutChangesToImmutableFields(t *testing.T) {
+	a := `{"name":"a","size":1}`
+	b := `{"name":"b","size":2}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), immutableTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/size", float64(2))}, patch)
+}
+
+func TestCreatePatch_StrictImmutableFields_ReturnsError(t *testing.T) {
+	a := `{"name":"a","size":1}`
+	b := `{"name":"b","size":2}`
+
+	_, err := CreatePatch([]byte(a), []byte(b), immutableTestCollections, PatchStrategyExactMatch, WithStrictImmutableFields())
+	var immutableErr *ImmutableFieldError
+	assert.True(t, errors.As(err, &immutableErr))
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/name", "b")}, immutableErr.Operations)
+	assert.EqualError(t, err, "changes to immutable fields require replacement: /name")
+
+	patch, err := CreatePatch([]byte(a), []byte(`{"name":"a","size":2}`), immutableTestCollections, PatchStrategyExactMatch, WithStrictImmutableFields())
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/size", float64(2))}, patch)
+}
+
+func TestCompile_InvalidImmutableField_ReturnsError(t *testing.T) {
+	_, err := Collections{ImmutableFields: []Path{"name"}}.Compile()
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePatch_WithIncludedFields_OnlyDiffsThem(t *testing.T) {
+	a := `{"name":"a","spec":{"replicas":1,"image":"x","paused":false},"status":{"ready":1}}`
+	b := `{"name":"b","spec":{"replicas":2,"image":"y","paused":true},"status":{"ready":2}}`
+	collections := Collections{IncludedFields: []Path{"$.spec.replicas", "$.spec.image"}}
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("replace", "/spec/image", "y"),
+		NewPatch("replace", "/spec/replicas", float64(2)),
+	}, patch)
+}
+
+func TestCreatePatch_WithIncludedFields_IntoEntitySets_KeepsTheKeys(t *testing.T) {
+	a := `{"t":[{"k":1,"v":1,"w":1},{"k":2,"v":2,"w":2}]}`
+	b := `{"t":[{"k":2,"v":3,"w":3},{"k":1,"v":1,"w":4}]}`
+	collections := Collections{
+		EntitySets:     EntitySets{"$.t": "k"},
+		IncludedFields: []Path{"$.t[*].v"},
+	}
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/t/1/v", float64(3))}, patch)
+}
+
+func TestCreatePatch_WithIncludedFields_IntoArrays_KeepsPositions(t *testing.T) {
+	a := `{"l":[{"n":"a","v":1},{"n":"b","v":2}]}`
+	b := `{"l":[{"n":"x","v":1},{"n":"y","v":3}]}`
+	collections := Collections{
+		Arrays:         []Path{"$.l"},
+		IncludedFields: []Path{"$.l[*].v"},
+	}
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/l/1/v", float64(3))}, patch)
+}
+
+func TestCreatePatch_WithIncludedFields_IntoSets_KeepsElementsWithoutThem(t *testing.T) {
+	a := `{"s":[{"n":1},{"v":1}]}`
+	b := `{"s":[{"v":2}]}`
+	collections := Collections{IncludedFields: []Path{"$.s[*].v"}}
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("remove", "/s/1", nil),
+		NewPatch("add", "/s/1", map[string]any{"v": float64(2)}),
+	}, patch)
+
+	reconciliation, err := Reconcile([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"s":[{"n":1},{"v":2}]}`, string(reconciliation.Result))
+}
+
+func TestCreatePatch_WithIncludedAndIgnoredFields_IgnoredFieldsWin(t *testing.T) {
+	a := `{"spec":{"replicas":1,"image":"x"},"other":1}`
+	b := `{"spec":{"replicas":2,"image":"y"},"other":2}`
+	collections := Collections{
+		IncludedFields: []Path{"$.spec"},
+		IgnoredFields:  []Path{"$.spec.image"},
+	}
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/spec/replicas", float64(2))}, patch)
+}
+
+func TestCreatePatch_WithIncludedFields_MissingInBoth_HasNoChanges(t *testing.T) {
+	patch, err := CreatePatch([]byte(`{"a":1}`), []byte(`{"a":2}`), Collections{IncludedFields: []Path{"$.b"}}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+}
+
+func TestCompile_InvalidIncludedField_ReturnsError(t *testing.T) {
+	_, err := Collections{IncludedFields: []Path{"spec"}}.Compile()
+	assert.Error(t, err)
+}
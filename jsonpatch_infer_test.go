@@ -0,0 +1,110 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var inferSample1 = `{
+  "name": "bucket",
+  "tags": [{"key":"env","value":"prod"},{"key":"team","value":"a"}],
+  "rules": [{"id":"r1","name":"same","prefix":"a/"},{"id":"r2","name":"same","prefix":"b/"}],
+  "regions": ["eu-west-1","us-east-1","ap-south-1"],
+  "steps": ["build","test","deploy"],
+  "ports": [80,80,443],
+  "statements": [{"effect":"Allow"},{"effect":"Deny"}]
+}`
+
+var inferSample2 = `{
+  "name": "other-bucket",
+  "tags": [{"key":"team","value":"b"},{"key":"owner","value":"c"},{"key":"env","value":"dev"}],
+  "rules": [{"id":"r3","name":"x","prefix":"c/"}],
+  "regions": ["us-east-1","eu-west-1"],
+  "steps": ["build","deploy"],
+  "ports": [22],
+  "statements": [{"effect":"Allow"}]
+}`
+
+func findProposal(inference *Inference, path Path) (Proposal, bool) {
+	for _, proposal := range inference.Proposals {
+		if proposal.Path == path {
+			return proposal, true
+		}
+	}
+	return Proposal{}, false
+}
+
+func TestInferCollections_ProposesEntitySetsAndSets(t *testing.T) {
+	inference, err := InferCollections([]byte(inferSample1), []byte(inferSample2))
+	assert.NoError(t, err)
+
+	tags, ok := findProposal(inference, "$.tags")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalEntitySet, tags.Kind)
+	assert.Equal(t, Key("key"), tags.Key)
+	assert.Greater(t, tags.Confidence, 0.6)
+	assert.Contains(t, tags.Reason, `"key"`)
+
+	// name is not unique, so id is the only candidate
+	rules, ok := findProposal(inference, "$.rules")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalEntitySet, rules.Kind)
+	assert.Equal(t, Key("id"), rules.Key)
+
+	regions, ok := findProposal(inference, "$.regions")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalSet, regions.Kind)
+	assert.Contains(t, regions.Reason, "/regions")
+
+	steps, ok := findProposal(inference, "$.steps")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalArray, steps.Kind)
+
+	ports, ok := findProposal(inference, "$.ports")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalArray, ports.Kind)
+	assert.Contains(t, ports.Reason, "repeat")
+
+	statements, ok := findProposal(inference, "$.statements")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalSet, statements.Kind)
+}
+
+func TestInferCollections_SingleElementArraysHaveLowConfidence(t *testing.T) {
+	inference, err := InferCollections([]byte(`{"t":[{"id":1}]}`))
+	assert.NoError(t, err)
+	proposal, ok := findProposal(inference, "$.t")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalEntitySet, proposal.Kind)
+	assert.Less(t, proposal.Confidence, 0.5)
+}
+
+func TestInferCollections_NestedArraysUseWildcardPaths(t *testing.T) {
+	inference, err := InferCollections([]byte(`{"t":[{"id":1,"v":[{"arn":"a"},{"arn":"b"}]},{"id":2,"v":[]}]}`))
+	assert.NoError(t, err)
+	proposal, ok := findProposal(inference, "$.t[*].v")
+	assert.True(t, ok)
+	assert.Equal(t, ProposalEntitySet, proposal.Kind)
+	assert.Equal(t, Key("arn"), proposal.Key)
+}
+
+func TestInferCollections_ExportsCollectionsAboveConfidence(t *testing.T) {
+	inference, err := InferCollections([]byte(inferSample1), []byte(inferSample2))
+	assert.NoError(t, err)
+
+	collections := inference.Collections(0.6)
+	assert.Equal(t, EntitySets{"$.rules": "id", "$.tags": "key"}, collections.EntitySets)
+	assert.Equal(t, []Path{"$.ports"}, collections.Arrays)
+	_, err = collections.Compile()
+	assert.NoError(t, err)
+
+	collections = inference.Collections(0)
+	assert.Equal(t, []Path{"$.ports", "$.steps"}, collections.Arrays)
+	assert.Contains(t, inference.String(), "$.tags\tentity-set (key key)")
+}
+
+func TestInferCollections_InvalidSample_ReturnsError(t *testing.T) {
+	_, err := InferCollections([]byte(`{}`), []byte(`{`))
+	assert.Error(t, err)
+}
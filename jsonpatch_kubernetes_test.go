@@ -0,0 +1,123 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var kubernetesOpenAPI = `{
+  "openapi": "3.0.0",
+  "components": {
+    "schemas": {
+      "io.k8s.api.apps.v1.Deployment": {
+        "type": "object",
+        "properties": {
+          "metadata": {"allOf": [{"$ref": "#/components/schemas/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"}]},
+          "spec": {"allOf": [{"$ref": "#/components/schemas/io.k8s.api.core.v1.PodSpec"}]}
+        }
+      },
+      "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+        "type": "object",
+        "properties": {
+          "finalizers": {"type": "array", "items": {"type": "string"}, "x-kubernetes-list-type": "set", "x-kubernetes-patch-strategy": "merge"},
+          "ownerReferences": {"type": "array", "items": {"type": "object"}, "x-kubernetes-list-type": "map", "x-kubernetes-list-map-keys": ["uid"]}
+        }
+      },
+      "io.k8s.api.core.v1.PodSpec": {
+        "type": "object",
+        "properties": {
+          "containers": {
+            "type": "array",
+            "items": {"allOf": [{"$ref": "#/components/schemas/io.k8s.api.core.v1.Container"}]},
+            "x-kubernetes-list-type": "map",
+            "x-kubernetes-list-map-keys": ["name"],
+            "x-kubernetes-patch-merge-key": "name",
+            "x-kubernetes-patch-strategy": "merge"
+          },
+          "volumes": {"type": "array", "items": {"type": "object"}, "x-kubernetes-patch-merge-key": "name", "x-kubernetes-patch-strategy": "merge,retainKeys"},
+          "nodeSelector": {"type": "object", "additionalProperties": {"type": "string"}, "x-kubernetes-map-type": "atomic"},
+          "tolerations": {"type": "array", "items": {"type": "object"}}
+        }
+      },
+      "io.k8s.api.core.v1.Container": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "args": {"type": "array", "items": {"type": "string"}, "x-kubernetes-list-type": "atomic"},
+          "ports": {
+            "type": "array",
+            "items": {"type": "object", "properties": {"containerPort": {"type": "integer"}, "protocol": {"type": "string"}}},
+            "x-kubernetes-list-type": "map",
+            "x-kubernetes-list-map-keys": ["containerPort", "protocol"]
+          }
+        }
+      }
+    }
+  }
+}`
+
+func TestCollectionsFromKubernetesOpenAPI_MapsListTypes(t *testing.T) {
+	collections, err := CollectionsFromKubernetesOpenAPI([]byte(kubernetesOpenAPI), "io.k8s.api.apps.v1.Deployment")
+	assert.NoError(t, err)
+	assert.Equal(t, EntitySets{
+		"$.metadata.ownerReferences": "uid",
+		"$.spec.containers":          "name",
+		"$.spec.containers[*].ports": CompositeKey("containerPort", "protocol"),
+		"$.spec.volumes":             "name",
+	}, collections.EntitySets)
+	assert.Empty(t, collections.Arrays)
+	assert.Equal(t, []Path{"$.spec.containers[*].args", "$.spec.nodeSelector", "$.spec.tolerations"}, collections.AtomicFields)
+}
+
+func TestCollectionsFromKubernetesOpenAPI_UnknownType_ReturnsError(t *testing.T) {
+	_, err := CollectionsFromKubernetesOpenAPI([]byte(kubernetesOpenAPI), "io.k8s.api.apps.v1.StatefulSet")
+	assert.EqualError(t, err, `type "io.k8s.api.apps.v1.StatefulSet" is not defined in the OpenAPI document`)
+}
+
+func TestCollectionsFromKubernetesSchema_InvalidListTypes_ReturnError(t *testing.T) {
+	invalid := []string{
+		`{`,
+		`{"properties": {"a": {"type": "array", "x-kubernetes-list-type": "map"}}}`,
+		`{"properties": {"a": {"type": "array", "x-kubernetes-list-type": "map", "x-kubernetes-list-map-keys": [1]}}}`,
+		`{"properties": {"a": {"type": "array", "x-kubernetes-list-type": "list"}}}`,
+	}
+	for _, schema := range invalid {
+		_, err := CollectionsFromKubernetesSchema([]byte(schema))
+		assert.Error(t, err, schema)
+	}
+}
+
+func TestCreatePatch_CompositeKey_MatchesOnAllFields(t *testing.T) {
+	collections := Collections{EntitySets: EntitySets{"$.ports": CompositeKey("containerPort", "protocol")}}
+	a := `{"ports":[{"containerPort":53,"protocol":"TCP","name":"dns-tcp"},{"containerPort":53,"protocol":"UDP","name":"dns"}]}`
+	b := `{"ports":[{"containerPort":53,"protocol":"UDP","name":"dns-udp"},{"containerPort":53,"protocol":"TCP","name":"dns-tcp"}]}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/ports/1/name", "dns-udp")}, patch)
+}
+
+func TestCreatePatch_AtomicFields_ReplacedAsAWhole(t *testing.T) {
+	collections := Collections{AtomicFields: []Path{"$.args", "$.selector"}}
+	a := `{"args":["--a","--b"],"selector":{"app":"web","tier":"front"}}`
+	b := `{"args":["--b","--a"],"selector":{"app":"web","tier":"back"}}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("replace", "/args", []any{"--b", "--a"}),
+		NewPatch("replace", "/selector", map[string]any{"app": "web", "tier": "back"}),
+	}, patch)
+
+	patch, err = CreatePatch([]byte(a), []byte(a), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Empty(t, patch)
+}
+
+func TestCompile_InvalidAtomicFieldsAndCompositeKeys_ReturnError(t *testing.T) {
+	_, err := Collections{Arrays: []Path{"$.a"}, AtomicFields: []Path{"$.a"}}.Compile()
+	assert.Error(t, err)
+	_, err = Collections{EntitySets: EntitySets{"$.a": CompositeKey("id", "")}}.Compile()
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var managedTestCollections = Collections{EntitySets: EntitySets{"$.spec.containers": "name"}}
+
+var managedLive = `{
+  "metadata": {"labels": {"app": "web", "team": "a"}, "finalizers": ["x"]},
+  "spec": {
+    "replicas": 1,
+    "containers": [{"name": "web", "image": "nginx:1"}, {"name": "sidecar", "image": "envoy:1"}]
+  }
+}`
+
+func managedFieldsOf(t *testing.T, manager, doc string) ManagedFields {
+	fields, err := mustCompile(t, managedTestCollections).FieldSet([]byte(doc))
+	assert.NoError(t, err)
+	return ManagedFields{manager: fields}
+}
+
+func TestFieldSet_TracksMembersElementsAndValues(t *testing.T) {
+	fields, err := mustCompile(t, managedTestCollections).FieldSet([]byte(managedLive))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/f:metadata/f:finalizers/v:\"x\"",
+		"/f:metadata/f:labels/f:app",
+		"/f:metadata/f:labels/f:team",
+		"/f:spec/f:containers/k:{\"name\":\"sidecar\"}",
+		"/f:spec/f:containers/k:{\"name\":\"sidecar\"}/f:image",
+		"/f:spec/f:containers/k:{\"name\":\"sidecar\"}/f:name",
+		"/f:spec/f:containers/k:{\"name\":\"web\"}",
+		"/f:spec/f:containers/k:{\"name\":\"web\"}/f:image",
+		"/f:spec/f:containers/k:{\"name\":\"web\"}/f:name",
+		"/f:spec/f:replicas",
+	}, fields.Fields())
+}
+
+func TestManagedFields_MarshalRoundTrips(t *testing.T) {
+	managed := managedFieldsOf(t, "deployer", managedLive)
+	data, err := json.Marshal(managed)
+	assert.NoError(t, err)
+	var roundTripped ManagedFields
+	assert.NoError(t, json.Unmarshal(data, &roundTripped))
+	assert.Equal(t, managed, roundTripped)
+}
+
+func TestApply_OwnedByAnotherManager_ReportsConflict(t *testing.T) {
+	managed := managedFieldsOf(t, "deployer", managedLive)
+	applied := `{"spec":{"replicas":3,"containers":[{"name":"web","image":"nginx:1","resources":{"cpu":"1"}}]}}`
+
+	result, err := Apply([]byte(managedLive), []byte(applied), "autoscaler", managed, managedTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("add", "/spec/containers/0/resources", map[string]any{"cpu": "1"}),
+	}, result.Patch)
+	assert.Equal(t, []Conflict{{Manager: "deployer", Field: "/f:spec/f:replicas", Path: "/spec/replicas"}}, result.Conflicts)
+
+	owned := result.ManagedFields["autoscaler"]
+	assert.False(t, owned.Has("/f:spec/f:replicas"))
+	// The image has the applied value already, so it is shared with the deployer.
+	assert.True(t, owned.Has("/f:spec/f:containers/k:{\"name\":\"web\"}/f:image"))
+	assert.True(t, owned.Has("/f:spec/f:containers/k:{\"name\":\"web\"}/f:resources/f:cpu"))
+	assert.Equal(t, managed["deployer"], result.ManagedFields["deployer"])
+}
+
+func TestApply_FieldsNoLongerApplied_AreRemovedUnlessOwnedByOthers(t *testing.T) {
+	managed := managedFieldsOf(t, "deployer", managedLive)
+	managed["labeler"] = FieldSet{"/f:metadata/f:labels/f:team": {}}
+	applied := `{"metadata":{"labels":{"app":"web"}},"spec":{"replicas":1,"containers":[{"name":"web","image":"nginx:2"}]}}`
+
+	result, err := Apply([]byte(managedLive), []byte(applied), "deployer", managed, managedTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("remove", "/metadata/finalizers/0", nil),
+		NewPatch("remove", "/spec/containers/1", nil),
+		NewPatch("replace", "/spec/containers/0/image", "nginx:2"),
+	}, result.Patch)
+	assert.Empty(t, result.Conflicts)
+	assert.False(t, result.ManagedFields["deployer"].Has("/f:metadata/f:labels/f:team"))
+	assert.True(t, result.ManagedFields["labeler"].Has("/f:metadata/f:labels/f:team"))
+}
+
+func TestApply_RemovedMember_IsRemovedExplicitly(t *testing.T) {
+	managed := managedFieldsOf(t, "deployer", managedLive)
+	applied := `{"metadata":{"labels":{"app":"web"},"finalizers":["x"]},"spec":{"containers":[{"name":"web","image":"nginx:1"},{"name":"sidecar","image":"envoy:1"}]}}`
+
+	result, err := Apply([]byte(managedLive), []byte(applied), "deployer", managed, managedTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("remove", "/metadata/labels/team", nil),
+		NewPatch("remove", "/spec/replicas", nil),
+	}, result.Patch)
+
+	result, err = Apply([]byte(managedLive), []byte(applied), "deployer", managed, managedTestCollections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Patch)
+}
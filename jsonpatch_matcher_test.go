@@ -0,0 +1,74 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePlan_SatisfiedMatchers_AreInSync(t *testing.T) {
+	a := `{"arn":"arn:aws:iam::123:role/x","id":"abc","size":5,"l":["x",3]}`
+	b := `{"arn":{"$match":"^arn:aws:iam::"},"id":{"$any":true},"size":{"$range":{"min":1,"max":10}},"l":[{"$any":true},{"$range":{"min":3}}]}`
+
+	plan, err := CreatePlan([]byte(a), []byte(b), Collections{Arrays: []Path{"$.l"}}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, plan.Patch)
+	assert.Equal(t, []Mismatch{}, plan.Mismatches)
+}
+
+func TestCreatePlan_UnsatisfiedMatchers_AreReportedAsMismatches(t *testing.T) {
+	a := `{"arn":"arn:aws:s3:::bucket","size":11,"other":1}`
+	b := `{"arn":{"$match":"^arn:aws:iam::"},"id":{"$any":true},"size":{"$range":{"max":10}},"other":2}`
+
+	plan, err := CreatePlan([]byte(a), []byte(b), Collections{}, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/other", float64(2))}, plan.Patch)
+	assert.Equal(t, []Mismatch{
+		{Path: "/arn", Matcher: map[string]any{"$match": "^arn:aws:iam::"}, Value: "arn:aws:s3:::bucket", Found: true},
+		{Path: "/id", Matcher: map[string]any{"$any": true}},
+		{Path: "/size", Matcher: map[string]any{"$range": map[string]any{"max": float64(10)}}, Value: float64(11), Found: true},
+	}, plan.Mismatches)
+}
+
+func TestCreatePlan_MatchersInAddedValues_AreLeftOutAndReported(t *testing.T) {
+	a := `{}`
+	b := `{"o":{"x":1,"id":{"$any":true}}}`
+
+	plan, err := CreatePlan([]byte(a), []byte(b), Collections{}, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("add", "/o", map[string]any{"x": float64(1)})}, plan.Patch)
+	assert.Equal(t, []Mismatch{{Path: "/o/id", Matcher: map[string]any{"$any": true}}}, plan.Mismatches)
+}
+
+func TestCreatePatch_Matchers_NeverGenerateOperations(t *testing.T) {
+	a := `{"a":"x"}`
+	b := `{"a":{"$match":"^y"},"b":{"$any":true}}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), Collections{}, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{}, patch)
+}
+
+func TestCreatePatch_InvalidMatcher_ReturnsError(t *testing.T) {
+	for _, b := range []string{
+		`{"a":{"$match":"("}}`,
+		`{"a":{"$match":1}}`,
+		`{"a":{"$any":false}}`,
+		`{"a":{"$range":{"least":1}}}`,
+	} {
+		_, err := CreatePatch([]byte(`{"a":1}`), []byte(b), Collections{}, PatchStrategyExactMatch)
+		assert.ErrorContains(t, err, "invalid matcher at /a", b)
+	}
+}
+
+func TestCreatePatch_InvalidMatcherInEntitySet_ReturnsError(t *testing.T) {
+	collections := Collections{EntitySets: EntitySets{"$.t": "k"}}
+	a := `{"t":[{"k":1,"arn":"x"}]}`
+	b := `{"t":[{"k":1,"arn":{"$match":"("}},{"k":2}]}`
+
+	_, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.ErrorContains(t, err, "invalid matcher at /t/0/arn")
+
+	_, _, err = HasDrift([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.ErrorContains(t, err, "invalid matcher at /t/0/arn")
+}
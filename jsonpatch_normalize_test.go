@@ -0,0 +1,67 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize_OrdersSetsEntitySetsAndMembers(t *testing.T) {
+	doc := `{"t":[{"v":2,"k":2},{"k":1,"v":{"b":1,"a":2}}],"s":["y","x",3],"a":100,"l":[2,1]}`
+	collections := Collections{
+		EntitySets: EntitySets{"$.t": "k"},
+		Arrays:     []Path{"$.l"},
+	}
+
+	normalized, err := Normalize([]byte(doc), collections)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":100,"l":[2,1],"s":["x","y",3],"t":[{"k":1,"v":{"a":2,"b":1}},{"k":2,"v":2}]}`, string(normalized))
+}
+
+func TestNormalize_EntitySetsWithCompositeKeys_AreOrderedByAllKeyFields(t *testing.T) {
+	doc := `{"t":[{"n":"b","p":1},{"n":"a","p":2},{"n":"a","p":1}]}`
+	collections := Collections{EntitySets: EntitySets{"$.t": CompositeKey("n", "p")}}
+
+	normalized, err := Normalize([]byte(doc), collections)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"t":[{"n":"a","p":1},{"n":"a","p":2},{"n":"b","p":1}]}`, string(normalized))
+}
+
+func TestNormalize_LeavesOutIgnoredFields(t *testing.T) {
+	doc := `{"a":1,"status":{"phase":"running"}}`
+
+	normalized, err := Normalize([]byte(doc), Collections{IgnoredFields: []Path{"$.status"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(normalized))
+}
+
+func TestNormalize_EqualDocuments_NormalizeToTheSameBytes(t *testing.T) {
+	a := `{"b":[{"c":[1,2,3]},{"c":[4,5]}],"t":[{"k":1,"v":1},{"k":2,"v":2}]}`
+	b := `{"t":[{"k":2,"v":2},{"v":1,"k":1}],"b":[{"c":[5,4]},{"c":[3,1,2]}]}`
+
+	equal, err := Equal([]byte(a), []byte(b), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.True(t, equal)
+
+	na, err := Normalize([]byte(a), entitySetTestCollections)
+	assert.NoError(t, err)
+	nb, err := Normalize([]byte(b), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.Equal(t, string(na), string(nb))
+}
+
+func TestNormalize_NegativeZero_NormalizesLikeZero(t *testing.T) {
+	a := `{"a":-0,"s":[0,-0.0],"t":[{"k":-0,"v":1}]}`
+	b := `{"a":0,"s":[-0,0],"t":[{"k":0,"v":1}]}`
+
+	equal, err := Equal([]byte(a), []byte(b), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.True(t, equal)
+
+	na, err := Normalize([]byte(a), entitySetTestCollections)
+	assert.NoError(t, err)
+	nb, err := Normalize([]byte(b), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.Equal(t, string(na), string(nb))
+	assert.JSONEq(t, `{"a":0,"s":[0,0],"t":[{"k":0,"v":1}]}`, string(na))
+}
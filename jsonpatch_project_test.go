@@ -0,0 +1,52 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProject_KeepsOnlyTheMembersInTheShape(t *testing.T) {
+	actual := `{"name":"web","replicas":3,"spec":{"image":"nginx","ports":[80,443],"env":"prod"},"status":"running"}`
+	shape := `{"name":"web","spec":{"image":"nginx:1","ports":[80],"missing":1}}`
+
+	projected, err := Project([]byte(actual), []byte(shape), Collections{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"web","spec":{"image":"nginx","ports":[80,443]}}`, string(projected))
+}
+
+func TestProject_MatchesEntitiesByKey(t *testing.T) {
+	actual := `{"t":[{"k":1,"v":1,"x":1},{"k":2,"v":2,"x":2},{"k":3,"v":3}]}`
+	shape := `{"t":[{"k":3},{"k":1,"v":0},{"k":4,"v":4}]}`
+
+	projected, err := Project([]byte(actual), []byte(shape), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"t":[{"k":3},{"k":1,"v":1}]}`, string(projected))
+}
+
+func TestProject_KeepsArrayPositions(t *testing.T) {
+	actual := `{"l":[{"a":1,"b":1},{"a":2,"b":2},{"a":3,"b":3}]}`
+	shape := `{"l":[{"a":0},{"b":0}]}`
+
+	projected, err := Project([]byte(actual), []byte(shape), Collections{Arrays: []Path{"$.l"}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"l":[{"a":1},{"b":2}]}`, string(projected))
+}
+
+func TestProject_AtomicFieldsMatchersAndTypeChanges_AreKeptWhole(t *testing.T) {
+	actual := `{"m":{"x":1,"y":2},"arn":"arn:aws:iam::1","o":{"a":1},"s":"x"}`
+	shape := `{"m":{"x":0},"arn":{"$match":"^arn"},"o":"a","s":{"a":1}}`
+
+	projected, err := Project([]byte(actual), []byte(shape), Collections{AtomicFields: []Path{"$.m"}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, actual, string(projected))
+}
+
+func TestProject_LeavesOutIgnoredFields(t *testing.T) {
+	actual := `{"a":1,"status":"running"}`
+	shape := `{"a":0,"status":"stopped"}`
+
+	projected, err := Project([]byte(actual), []byte(shape), Collections{IgnoredFields: []Path{"$.status"}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(projected))
+}
@@ -0,0 +1,113 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile_EnsureExists_ReturnsMergedDocument(t *testing.T) {
+	reconciliation, err := Reconcile([]byte(simpleObjEntitySet), []byte(`{"t":[{"k":3}]}`), entitySetTestCollections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("add", "/t/2", map[string]any{"k": float64(3)})}, reconciliation.Patch)
+	assert.JSONEq(t, `{"a":100,"t":[{"k":1,"v":1},{"k":2,"v":2},{"k":3}]}`, string(reconciliation.Result))
+}
+
+func TestReconcile_ResultHasNoFurtherChanges(t *testing.T) {
+	cases := []struct {
+		actual, desired string
+		collections     Collections
+	}{
+		{simpleObjEntitySet, simpleObjModifyEntitySetItem, entitySetTestCollections},
+		{simpleObjEntitySet, simpleObjAddMultipleDuplicateAndFailedItems, entitySetTestCollections},
+		{simpleObjEntitySet, simpleObjEntitySetRemoveItem, entitySetTestCollections},
+		{complexNextedEntitySet, complexNextedEntitySetModifyItem, entitySetTestCollections},
+		{`{"s":[1,2,3],"a":[1,2,2,3]}`, `{"s":[3,4],"a":[2,3,3]}`, Collections{Arrays: []Path{"$.a"}}},
+		{`{"o":{"x":1}}`, `{"o":"x","n":null,"m":{"y":[{"z":1}]}}`, Collections{}},
+	}
+	for _, strategy := range []PatchStrategy{PatchStrategyExactMatch, PatchStrategyEnsureExists} {
+		for _, c := range cases {
+			reconciliation, err := Reconcile([]byte(c.actual), []byte(c.desired), c.collections, strategy)
+			if !assert.NoError(t, err, "%s: %s -> %s", strategy, c.actual, c.desired) {
+				continue
+			}
+			patch, err := CreatePatch(reconciliation.Result, []byte(c.desired), c.collections, strategy)
+			assert.NoError(t, err)
+			assert.Empty(t, patch, "%s: %s -> %s", strategy, c.actual, c.desired)
+		}
+	}
+}
+
+func TestReconcile_ReorderedArrays_AreInOrderAfterOnePatch(t *testing.T) {
+	cases := []struct {
+		actual, desired, result string
+		strategy                PatchStrategy
+	}{
+		{`{"a":[1,2]}`, `{"a":[2,1,3]}`, `{"a":[2,1,3]}`, PatchStrategyExactMatch},
+		{`{"a":[[1,2],[3]]}`, `{"a":[[3],[1,2],[4]]}`, `{"a":[[3],[1,2],[4]]}`, PatchStrategyExactMatch},
+		{`{"a":[{"a":1},{"b":1}]}`, `{"a":[{"c":1},{"a":1},{"b":1}]}`, `{"a":[{"c":1},{"a":1},{"b":1}]}`, PatchStrategyEnsureExists},
+		{`{"a":[[1,2],[3]]}`, `{"a":[[3],[1,2],[4]]}`, `{"a":[[1,2],[3],[1,2],[4]]}`, PatchStrategyEnsureExists},
+		{`{"a":[5,1,6,2]}`, `{"a":[1,7,2]}`, `{"a":[5,1,6,7,2]}`, PatchStrategyEnsureExists},
+	}
+	collections := Collections{Arrays: []Path{"$.a"}}
+	for _, c := range cases {
+		reconciliation, err := Reconcile([]byte(c.actual), []byte(c.desired), collections, c.strategy)
+		if !assert.NoError(t, err, c.desired) {
+			continue
+		}
+		assert.JSONEq(t, c.result, string(reconciliation.Result), "%s: %s -> %s", c.strategy, c.actual, c.desired)
+
+		patch, err := CreatePatch([]byte(c.actual), []byte(c.desired), collections, c.strategy)
+		assert.NoError(t, err)
+		assert.Equal(t, patch, reconciliation.Patch)
+	}
+
+	reconciliation, err := Reconcile([]byte(`{"a":[{"a":1},{"b":1}]}`), []byte(`{"a":[{"c":1},{"a":1},{"b":1}]}`), collections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("add", "/a/0", map[string]any{"c": float64(1)})}, reconciliation.Patch)
+}
+
+func TestReconcile_LaterOperations_DoNotChangeThePatch(t *testing.T) {
+	var doc any = map[string]any{"a": []any{}}
+	patch := []JsonPatchOperation{
+		NewPatch("add", "/a/0", map[string]any{"c": float64(1)}),
+		NewPatch("add", "/a/0/b", float64(1)),
+	}
+	var err error
+	for _, op := range patch {
+		doc, err = applyOperation(doc, op)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, map[string]any{"a": []any{map[string]any{"b": float64(1), "c": float64(1)}}}, doc)
+	assert.Equal(t, map[string]any{"c": float64(1)}, patch[0].Value)
+}
+
+func TestReconcile_ImmutableFields_AreLeftUnchanged(t *testing.T) {
+	collections := Collections{ImmutableFields: []Path{"$.name"}}
+	reconciliation, err := Reconcile([]byte(`{"name":"a","size":1}`), []byte(`{"name":"b","size":2}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"a","size":2}`, string(reconciliation.Result))
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/name", "b")}, reconciliation.RequiresReplacement)
+}
+
+func TestReconcile_KeepsIgnoredFields(t *testing.T) {
+	collections := Collections{IgnoredFields: []Path{"$.status"}}
+	reconciliation, err := Reconcile([]byte(`{"status":"ok","size":1}`), []byte(`{"status":"failed","size":2}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"status":"ok","size":2}`, string(reconciliation.Result))
+}
+
+func TestApplyOperation_InvalidPaths_ReturnError(t *testing.T) {
+	doc := map[string]any{"a": []any{float64(1)}}
+	for _, op := range []JsonPatchOperation{
+		NewPatch("replace", "/b", 1),
+		NewPatch("add", "/b/c", 1),
+		NewPatch("add", "/a/2", 1),
+		NewPatch("remove", "/a/x", nil),
+		NewPatch("remove", "", nil),
+		NewPatch("move", "/a/0", nil),
+	} {
+		_, err := applyOperation(doc, op)
+		assert.Error(t, err, op.Json())
+	}
+}
@@ -0,0 +1,103 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var bucketSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "arn": {"type": "string", "readOnly": true},
+    "name": {"type": "string"},
+    "tags": {"type": "array", "uniqueItems": true, "items": {"$ref": "#/$defs/tag"}},
+    "regions": {"type": "array", "uniqueItems": true, "items": {"type": "string"}},
+    "steps": {"type": "array", "items": {"type": "string"}},
+    "rules": {
+      "type": "array",
+      "x-identity": "id",
+      "items": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "created": {"type": "string", "readOnly": true},
+          "transitions": {"type": "array", "items": {"type": "object", "properties": {"days": {"type": "integer"}, "status": {"readOnly": true}}}}
+        }
+      }
+    },
+    "policy": {"allOf": [{"$ref": "#/definitions/policy"}]}
+  },
+  "$defs": {
+    "tag": {"type": "object", "x-identity": "key", "properties": {"key": {"type": "string"}, "value": {"type": "string"}}}
+  },
+  "definitions": {
+    "policy": {"type": "object", "properties": {"statements": {"type": ["array", "null"], "uniqueItems": true}}}
+  }
+}`
+
+func TestCollectionsFromJsonSchema_DerivesCollections(t *testing.T) {
+	collections, err := CollectionsFromJsonSchema([]byte(bucketSchema))
+	assert.NoError(t, err)
+	assert.Equal(t, EntitySets{"$.rules": "id", "$.tags": "key"}, collections.EntitySets)
+	assert.Equal(t, []Path{"$.rules[*].transitions", "$.steps"}, collections.Arrays)
+	assert.Equal(t, []Path{"$.arn", "$.rules[*].created", "$.rules[*].transitions[*].status"}, collections.IgnoredFields)
+}
+
+func TestCollectionsFromJsonSchema_ReadOnlyItems_IgnoreTheArray(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"endpoints": {"type": "array", "items": {"type": "string", "readOnly": true}},
+			"matrix": {"type": "array", "items": {"type": "array", "items": {"type": "integer", "readOnly": true}}}
+		}
+	}`
+
+	collections, err := CollectionsFromJsonSchema([]byte(schema))
+	assert.NoError(t, err)
+	assert.Equal(t, []Path{"$.endpoints", "$.matrix"}, collections.IgnoredFields)
+	_, err = collections.Compile()
+	assert.NoError(t, err)
+
+	patch, err := CreatePatch([]byte(`{"name":"a","endpoints":["x"]}`), []byte(`{"name":"b","endpoints":["y"]}`), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/name", "b")}, patch)
+}
+
+func TestCollectionsFromJsonSchema_StopsAtRecursiveReferences(t *testing.T) {
+	schema := `{
+      "$ref": "#/definitions/node",
+      "definitions": {
+        "node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/definitions/node"}}}}
+      }
+    }`
+	collections, err := CollectionsFromJsonSchema([]byte(schema))
+	assert.NoError(t, err)
+	assert.Equal(t, []Path{"$.children"}, collections.Arrays)
+}
+
+func TestCollectionsFromJsonSchema_Errors(t *testing.T) {
+	invalid := []string{
+		`{`,
+		`{"properties": {"a": {"$ref": "#/definitions/missing"}}}`,
+		`{"properties": {"a": {"$ref": "other.json#/definitions/a"}}}`,
+		`{"properties": {"a": {"type": "array", "x-identity": 1}}}`,
+		`{"properties": {"a.b": {"type": "string"}}}`,
+	}
+	for _, schema := range invalid {
+		_, err := CollectionsFromJsonSchema([]byte(schema))
+		assert.Error(t, err, schema)
+	}
+}
+
+func TestCreatePatch_WithSchemaCollections_IgnoresNestedReadOnlyFields(t *testing.T) {
+	collections, err := CollectionsFromJsonSchema([]byte(bucketSchema))
+	assert.NoError(t, err)
+	a := `{"arn":"arn:1","rules":[{"id":"r1","created":"yesterday","transitions":[{"days":1,"status":"done"}]}]}`
+	b := `{"arn":"arn:2","rules":[{"id":"r1","created":"today","transitions":[{"days":1,"status":"pending"}]}]}`
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(patch), "they should be equal")
+}
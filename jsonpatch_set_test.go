@@ -224,3 +224,13 @@ func TestCreatePatch_AddDuplicateItemToObjectSetWithOneItem_InExactMatchMode_Gen
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(patch), "they should be equal")
 }
+
+func TestCreatePatch_AddItemAfterCommonItemsToPrimitiveSet_InExactMatchMode_AddsAtTheEnd(t *testing.T) {
+	patch, err := CreatePatch([]byte(`{"b":[1,2,3]}`), []byte(`{"b":[3,4]}`), setTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("remove", "/b/1", nil),
+		NewPatch("remove", "/b/0", nil),
+		NewPatch("add", "/b/1", float64(4)),
+	}, patch)
+}
@@ -0,0 +1,79 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var setAlgebraA = `{"a":1,"b":"x","s":[1,2,3],"t":[{"k":1,"v":1},{"k":2,"v":2,"w":"a"}],"only":{"a":true}}`
+var setAlgebraB = `{"a":1,"b":"y","s":[3,4],"t":[{"k":2,"v":2,"w":"b"},{"k":3,"v":3}],"c":[1]}`
+
+func TestUnion(t *testing.T) {
+	result, err := Union([]byte(setAlgebraA), []byte(setAlgebraB), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":1,"b":"x","c":[1],"s":[1,2,3,4],"t":[{"k":1,"v":1},{"k":2,"v":2,"w":"a"},{"k":3,"v":3}],"only":{"a":true}}`, string(result.Document))
+	assert.Equal(t, []ValueConflict{
+		{Path: "/b", A: "x", B: "y"},
+		{Path: "/t/1/w", A: "a", B: "b"},
+	}, result.Conflicts)
+}
+
+func TestIntersect(t *testing.T) {
+	result, err := Intersect([]byte(setAlgebraA), []byte(setAlgebraB), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":1,"s":[3],"t":[{"k":2,"v":2}]}`, string(result.Document))
+	assert.Equal(t, []ValueConflict{
+		{Path: "/b", A: "x", B: "y"},
+		{Path: "/t/1/w", A: "a", B: "b"},
+	}, result.Conflicts)
+}
+
+func TestSubtract(t *testing.T) {
+	result, err := Subtract([]byte(setAlgebraA), []byte(setAlgebraB), entitySetTestCollections)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"b":"x","s":[1,2],"t":[{"k":1,"v":1},{"k":2,"w":"a"}],"only":{"a":true}}`, string(result.Document))
+	assert.Equal(t, []ValueConflict{
+		{Path: "/b", A: "x", B: "y"},
+		{Path: "/t/1/w", A: "a", B: "b"},
+	}, result.Conflicts)
+}
+
+func TestSubtract_Baseline_LeavesWhatWasAdded(t *testing.T) {
+	baseline := `{"name":"web","tags":["a"],"rules":[{"k":1,"v":1}]}`
+	actual := `{"name":"web","tags":["b","a"],"rules":[{"k":1,"v":1},{"k":2,"v":2}]}`
+	collections := Collections{EntitySets: EntitySets{"$.rules": "k"}}
+
+	result, err := Subtract([]byte(actual), []byte(baseline), collections)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"tags":["b"],"rules":[{"k":2,"v":2}]}`, string(result.Document))
+	assert.Equal(t, []ValueConflict{}, result.Conflicts)
+
+	result, err = Subtract([]byte(baseline), []byte(baseline), collections)
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(result.Document))
+}
+
+func TestSetAlgebra_ArraysAndAtomicFields_AreValues(t *testing.T) {
+	a := `{"l":[1,2],"m":{"x":1}}`
+	b := `{"l":[2,1],"m":{"x":2,"y":1}}`
+	collections := Collections{Arrays: []Path{"$.l"}, AtomicFields: []Path{"$.m"}}
+
+	result, err := Union([]byte(a), []byte(b), collections)
+	assert.NoError(t, err)
+	assert.JSONEq(t, a, string(result.Document))
+	assert.Equal(t, []ValueConflict{
+		{Path: "/l", A: []any{float64(1), float64(2)}, B: []any{float64(2), float64(1)}},
+		{Path: "/m", A: map[string]any{"x": float64(1)}, B: map[string]any{"x": float64(2), "y": float64(1)}},
+	}, result.Conflicts)
+}
+
+func TestSetAlgebra_IgnoresIgnoredFields(t *testing.T) {
+	a := `{"a":1,"status":"running"}`
+	b := `{"a":1,"status":"stopped"}`
+
+	result, err := Intersect([]byte(a), []byte(b), Collections{IgnoredFields: []Path{"$.status"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(result.Document))
+	assert.Equal(t, []ValueConflict{}, result.Conflicts)
+}
@@ -101,8 +101,9 @@ func BenchmarkBigArrays(b *testing.B) {
 		a1[i] = i
 		a2[i+1] = i
 	}
+	collections := mustCompile(b, Collections{})
 	for i := 0; i < b.N; i++ {
-		compareArray(a1, a2, "/", PatchStrategyExactMatch, Collections{})
+		compareArray(a1, a2, "/", PatchStrategyExactMatch, collections, newDiffState(collections, options{}))
 	}
 }
 
@@ -115,7 +116,8 @@ func BenchmarkBigArrays2(b *testing.B) {
 		a1[i] = i
 		a2[i] = i
 	}
+	collections := mustCompile(b, Collections{})
 	for i := 0; i < b.N; i++ {
-		compareArray(a1, a2, "/", PatchStrategyExactMatch, Collections{})
+		compareArray(a1, a2, "/", PatchStrategyExactMatch, collections, newDiffState(collections, options{}))
 	}
 }
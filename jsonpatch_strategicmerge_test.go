@@ -0,0 +1,105 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var smpTestCollections = Collections{
+	EntitySets: EntitySets{
+		"$.spec.containers":          "name",
+		"$.spec.containers[*].ports": CompositeKey("containerPort", "protocol"),
+	},
+	Arrays:       []Path{"$.spec.containers[*].args"},
+	AtomicFields: []Path{"$.spec.selector"},
+}
+
+func TestCreateStrategicMergePatch_EntitySet_UsesKeyAsMergeKey(t *testing.T) {
+	a := `{"spec":{"containers":[{"name":"web","image":"nginx:1"},{"name":"sidecar","image":"envoy"},{"name":"old","image":"busybox"}]}}`
+	b := `{"spec":{"containers":[{"name":"web","image":"nginx:2"},{"name":"sidecar","image":"envoy"},{"name":"new","image":"redis"}]}}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), smpTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"spec":{
+		"$setElementOrder/containers":[{"name":"web"},{"name":"sidecar"},{"name":"new"}],
+		"containers":[
+			{"name":"web","image":"nginx:2"},
+			{"name":"new","image":"redis"},
+			{"name":"old","$patch":"delete"}
+		]
+	}}`, string(patch))
+}
+
+func TestCreateStrategicMergePatch_PrimitiveSet_DeletesFromPrimitiveList(t *testing.T) {
+	a := `{"metadata":{"finalizers":["a","b"]}}`
+	b := `{"metadata":{"finalizers":["c","a"]}}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), smpTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"metadata":{
+		"finalizers":["c"],
+		"$deleteFromPrimitiveList/finalizers":["b"],
+		"$setElementOrder/finalizers":["c","a"]
+	}}`, string(patch))
+
+	patch, err = CreateStrategicMergePatch([]byte(a), []byte(b), smpTestCollections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"metadata":{"finalizers":["c"]}}`, string(patch))
+}
+
+func TestCreateStrategicMergePatch_ArraysAndAtomicFields_AreReplaced(t *testing.T) {
+	a := `{"spec":{"selector":{"app":"web","tier":"front"},"containers":[{"name":"web","args":["-a","-b"]}]}}`
+	b := `{"spec":{"selector":{"app":"web"},"containers":[{"name":"web","args":["-b","-a"]}]}}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), smpTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"spec":{
+		"selector":{"app":"web"},
+		"$setElementOrder/containers":[{"name":"web"}],
+		"containers":[{"name":"web","args":["-b","-a"]}]
+	}}`, string(patch))
+}
+
+func TestCreateStrategicMergePatch_CompositeKey_ReplacesList(t *testing.T) {
+	a := `{"spec":{"containers":[{"name":"web","ports":[{"containerPort":80,"protocol":"TCP"}]}]}}`
+	b := `{"spec":{"containers":[{"name":"web","ports":[{"containerPort":443,"protocol":"TCP"}]}]}}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), smpTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"spec":{
+		"$setElementOrder/containers":[{"name":"web"}],
+		"containers":[{"name":"web","ports":[{"containerPort":443,"protocol":"TCP"},{"$patch":"replace"}]}]
+	}}`, string(patch))
+}
+
+func TestCreateStrategicMergePatch_NoChanges_ReturnsEmptyPatch(t *testing.T) {
+	a := `{"spec":{"containers":[{"name":"a"},{"name":"b"}]},"metadata":{"finalizers":["a","b"]}}`
+	b := `{"metadata":{"finalizers":["b","a"]},"spec":{"containers":[{"name":"b"},{"name":"a"}]}}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), smpTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(patch))
+}
+
+func TestCreateStrategicMergePatch_ImmutableFields(t *testing.T) {
+	collections := Collections{ImmutableFields: []Path{"$.spec.selector"}}
+	a := `{"spec":{"selector":{"app":"a"},"replicas":1}}`
+	b := `{"spec":{"selector":{"app":"b"},"replicas":2}}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"spec":{"replicas":2}}`, string(patch))
+
+	_, err = CreateStrategicMergePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch, WithStrictImmutableFields())
+	var immutableErr *ImmutableFieldError
+	assert.True(t, errors.As(err, &immutableErr))
+}
+
+func TestCreateStrategicMergePatch_NotAnObject_ReturnsError(t *testing.T) {
+	_, err := CreateStrategicMergePatch([]byte(`[1]`), []byte(`[2]`), Collections{}, PatchStrategyExactMatch)
+	assert.Error(t, err)
+	_, err = CreateStrategicMergePatch([]byte(`{`), []byte(`{}`), Collections{}, PatchStrategyExactMatch)
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type structTagsMetadata struct {
+	Arn     string `json:"arn" jsonpatch:"ignore"`
+	Created string `json:"created,omitempty" jsonpatch:"ignore"`
+}
+
+type structTagsTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type structTagsTransition struct {
+	Days   int    `json:"days"`
+	Status string `json:"status" jsonpatch:"ignore"`
+}
+
+type structTagsRule struct {
+	ID          string                  `json:"id"`
+	Transitions []*structTagsTransition `json:"transitions" jsonpatch:"array"`
+	Prefixes    []string                `json:"prefixes" jsonpatch:"set"`
+}
+
+type structTagsNode struct {
+	Name     string            `json:"name"`
+	Children []*structTagsNode `json:"children" jsonpatch:"array"`
+}
+
+type structTagsBucket struct {
+	structTagsMetadata
+	Name     string           `json:"name"`
+	Tags     []structTagsTag  `json:"tags" jsonpatch:"entityset,key=key"`
+	Rules    []structTagsRule `json:"rules,omitempty" jsonpatch:"entityset,key=id"`
+	Steps    []string         `jsonpatch:"array"`
+	Root     *structTagsNode  `json:"root"`
+	Labels   map[string]string
+	Payload  []byte `json:"payload"`
+	Internal string `json:"-" jsonpatch:"ignore"`
+	hidden   []string
+}
+
+func TestCollectionsFromStruct_DerivesCollections(t *testing.T) {
+	collections, err := CollectionsFromStruct(&structTagsBucket{})
+	assert.NoError(t, err)
+	assert.Equal(t, EntitySets{"$.tags": "key", "$.rules": "id"}, collections.EntitySets)
+	assert.Equal(t, []Path{"$.rules[*].transitions", "$.Steps", "$.root.children"}, collections.Arrays)
+	assert.Equal(t, []Path{"$.arn", "$.created", "$.rules[*].transitions[*].status"}, collections.IgnoredFields)
+}
+
+func TestCollectionsFromStruct_Errors(t *testing.T) {
+	invalid := []any{
+		"not a struct",
+		struct {
+			A string `jsonpatch:"array"`
+		}{},
+		struct {
+			A []string `jsonpatch:"entityset,key=id"`
+		}{},
+		struct {
+			A []structTagsTag `jsonpatch:"entityset,key=id"`
+		}{},
+		struct {
+			A []structTagsTag `jsonpatch:"entityset"`
+		}{},
+		struct {
+			A []string `jsonpatch:"list"`
+		}{},
+		struct {
+			A []string `jsonpatch:"set,ordered"`
+		}{},
+	}
+	for _, v := range invalid {
+		_, err := CollectionsFromStruct(v)
+		assert.Error(t, err, "%T", v)
+	}
+}
+
+func TestCreatePatch_WithStructCollections_UsesEntitySetKeys(t *testing.T) {
+	collections, err := CollectionsFromStruct(structTagsBucket{})
+	assert.NoError(t, err)
+	a := `{"arn":"arn:1","tags":[{"key":"env","value":"prod"},{"key":"team","value":"a"}]}`
+	b := `{"arn":"arn:2","tags":[{"key":"team","value":"b"},{"key":"env","value":"prod"}]}`
+	patch, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("replace", "/tags/1/value", "b")}, patch)
+}
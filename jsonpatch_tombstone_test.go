@@ -0,0 +1,100 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePatch_DeletedMember_InEnsureExistsMode_GeneratesRemoveOperation(t *testing.T) {
+	a := `{"a":100,"b":{"c":1,"d":2}}`
+	b := `{"a":{"$delete":true},"b":{"d":{"$delete":true}},"x":{"$delete":true}}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), Collections{}, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("remove", "/a", nil),
+		NewPatch("remove", "/b/d", nil),
+	}, patch)
+}
+
+func TestCreatePatch_DeletedEntity_InEnsureExistsMode_GeneratesRemoveOperation(t *testing.T) {
+	b := `{"t":[{"k":1,"$delete":true},{"k":2,"v":3},{"k":4,"$delete":true}]}`
+
+	patch, err := CreatePatch([]byte(simpleObjEntitySet), []byte(b), entitySetTestCollections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("remove", "/t/0", nil),
+		NewPatch("replace", "/t/0/v", float64(3)),
+	}, patch)
+}
+
+func TestCreatePatch_DeletedEntity_InExactMatchMode_IsNeverAdded(t *testing.T) {
+	b := `{"t":[{"k":1,"v":1},{"k":3,"$delete":true}]}`
+
+	patch, err := CreatePatch([]byte(simpleObjEntitySet), []byte(b), entitySetTestCollections, PatchStrategyExactMatch)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{NewPatch("remove", "/t/1", nil)}, patch)
+}
+
+func TestCreatePatch_TombstonesInAddedValues_AreLeftOut(t *testing.T) {
+	a := `{"t":[]}`
+	b := `{"o":{"x":1,"y":{"$delete":true}},"t":[{"k":1,"v":{"w":{"$delete":true}}}]}`
+
+	patch, err := CreatePatch([]byte(a), []byte(b), entitySetTestCollections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.Equal(t, []JsonPatchOperation{
+		NewPatch("add", "/o", map[string]any{"x": float64(1)}),
+		NewPatch("add", "/t/0", map[string]any{"k": float64(1), "v": map[string]any{}}),
+	}, patch)
+}
+
+func TestReconcile_Tombstones_Converge(t *testing.T) {
+	b := `{"a":{"$delete":true},"t":[{"k":2,"$delete":true}]}`
+
+	reconciliation, err := Reconcile([]byte(simpleObjEntitySet), []byte(b), entitySetTestCollections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"t":[{"k":1,"v":1}]}`, string(reconciliation.Result))
+}
+
+func TestCreateStrategicMergePatch_Tombstones_BecomeDeletes(t *testing.T) {
+	collections := Collections{EntitySets: EntitySets{"$.spec.containers": "name"}}
+	a := `{"metadata":{"annotations":{"x":"1"}},"spec":{"containers":[{"name":"web"},{"name":"old"}]}}`
+	b := `{"metadata":{"annotations":{"x":{"$delete":true}}},"spec":{"containers":[{"name":"old","$delete":true}]}}`
+
+	patch, err := CreateStrategicMergePatch([]byte(a), []byte(b), collections, PatchStrategyEnsureExists)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"metadata":{"annotations":{"x":null}},"spec":{"containers":[{"name":"old","$patch":"delete"}]}}`, string(patch))
+}
+
+func TestCreatePatch_DeletedElementInSet_ReturnsError(t *testing.T) {
+	a := `{"s":[1,2]}`
+	b := `{"s":[1,{"$delete":true},3]}`
+
+	_, err := CreatePatch([]byte(a), []byte(b), Collections{}, PatchStrategyExactMatch)
+	assert.EqualError(t, err, "$delete at /s: only the elements of entity sets can be deleted")
+
+	_, err = Reconcile([]byte(a), []byte(b), Collections{}, PatchStrategyEnsureExists)
+	assert.EqualError(t, err, "$delete at /s: only the elements of entity sets can be deleted")
+}
+
+func TestCreatePatch_DeletedElementInArray_ReturnsError(t *testing.T) {
+	collections := Collections{Arrays: []Path{"$.l"}}
+
+	for _, b := range []string{`{"l":[1,{"$delete":true},3]}`, `{"l":[1,{"$delete":true}]}`} {
+		_, err := CreatePatch([]byte(`{"l":[1,2]}`), []byte(b), collections, PatchStrategyExactMatch)
+		assert.EqualError(t, err, "$delete at /l: only the elements of entity sets can be deleted", b)
+	}
+}
+
+func TestCreatePatch_DeletedElementInSetInEntitySet_ReturnsError(t *testing.T) {
+	collections := Collections{EntitySets: EntitySets{"$.t": "k"}}
+	a := `{"t":[{"k":1,"s":[1]}]}`
+	b := `{"t":[{"k":1,"s":[1,{"$delete":true}]},{"k":2}]}`
+
+	_, err := CreatePatch([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.EqualError(t, err, "$delete at /t/0/s: only the elements of entity sets can be deleted")
+
+	_, _, err = HasDrift([]byte(a), []byte(b), collections, PatchStrategyExactMatch)
+	assert.EqualError(t, err, "$delete at /t/0/s: only the elements of entity sets can be deleted")
+}
@@ -0,0 +1,97 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CollectionsFromKubernetesSchema derives Collections from the OpenAPI schema of a Kubernetes type, such as the
+// `openAPIV3Schema` of a CustomResourceDefinition, so manifests can be diffed the way the API server merges them.
+//
+//   - `x-kubernetes-list-type: map` becomes an entity set keyed on `x-kubernetes-list-map-keys`, with a CompositeKey
+//     when there is more than one
+//   - `x-kubernetes-list-type: set` becomes a set
+//   - `x-kubernetes-list-type: atomic` and `x-kubernetes-map-type: atomic` become AtomicFields
+//
+// Lists without a list type fall back to `x-kubernetes-patch-strategy: merge` and `x-kubernetes-patch-merge-key`,
+// and are atomic otherwise, which is what Kubernetes assumes for them.
+func CollectionsFromKubernetesSchema(schema []byte) (Collections, error) {
+	var root any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return Collections{}, errBadJsonDoc
+	}
+	return collectionsFromKubernetesSchema(root, root)
+}
+
+// CollectionsFromKubernetesOpenAPI derives Collections like CollectionsFromKubernetesSchema for the type `typeName`
+// of a Kubernetes OpenAPI document, as served by the API server at `/openapi/v3/...` (`components.schemas`) or
+// `/openapi/v2` (`definitions`), e.g. `io.k8s.api.apps.v1.Deployment`.
+func CollectionsFromKubernetesOpenAPI(document []byte, typeName string) (Collections, error) {
+	var root any
+	if err := json.Unmarshal(document, &root); err != nil {
+		return Collections{}, errBadJsonDoc
+	}
+	for _, ref := range []string{"#/components/schemas/", "#/definitions/"} {
+		if schema, err := resolveSchemaRef(root, ref+rfc6901Encoder.Replace(typeName)); err == nil {
+			return collectionsFromKubernetesSchema(root, schema)
+		}
+	}
+	return Collections{}, fmt.Errorf("type %q is not defined in the OpenAPI document", typeName)
+}
+
+func collectionsFromKubernetesSchema(root, schema any) (Collections, error) {
+	w := &schemaWalker{
+		root:         root,
+		classify:     classifyKubernetesList,
+		atomicObject: kubernetesAtomicMap,
+		entitySets:   EntitySets{},
+	}
+	if err := w.walk(schema, "$"); err != nil {
+		return Collections{}, err
+	}
+	return w.collections()
+}
+
+// classifyKubernetesList classifies a list of a Kubernetes schema by its list type.
+func classifyKubernetesList(_ *schemaWalker, list map[string]any, _ any) (collectionKind, Key, error) {
+	listType, _ := list["x-kubernetes-list-type"].(string)
+	switch listType {
+	case "map":
+		keys, _ := list["x-kubernetes-list-map-keys"].([]any)
+		fields := make([]string, 0, len(keys))
+		for _, key := range keys {
+			field, ok := key.(string)
+			if !ok || field == "" || strings.Contains(field, ",") {
+				return 0, "", fmt.Errorf("x-kubernetes-list-map-keys must be a list of field names, got %v", keys)
+			}
+			fields = append(fields, field)
+		}
+		if len(fields) == 0 {
+			return 0, "", fmt.Errorf("x-kubernetes-list-type map needs x-kubernetes-list-map-keys")
+		}
+		return collectionEntitySet, CompositeKey(fields...), nil
+	case "set":
+		return collectionSet, "", nil
+	case "atomic":
+		return collectionAtomic, "", nil
+	case "":
+	default:
+		return 0, "", fmt.Errorf("unknown x-kubernetes-list-type %q", listType)
+	}
+
+	strategy, _ := list["x-kubernetes-patch-strategy"].(string)
+	if !slices.Contains(strings.Split(strategy, ","), "merge") {
+		return collectionAtomic, "", nil
+	}
+	if key, _ := list["x-kubernetes-patch-merge-key"].(string); key != "" {
+		return collectionEntitySet, Key(key), nil
+	}
+	return collectionSet, "", nil
+}
+
+func kubernetesAtomicMap(object map[string]any) bool {
+	mapType, _ := object["x-kubernetes-map-type"].(string)
+	return mapType == "atomic"
+}
@@ -0,0 +1,404 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// FieldSet is the set of fields a manager owns, in the spirit of Kubernetes server side apply. A field is written as
+// a path of segments: `f:<name>` for an object member, `k:<key fields>` for an element of an entity set, e.g.
+// `k:{"name":"web"}`, and `v:<value>` for a value of a set of primitives, e.g.
+// `/f:spec/f:containers/k:{"name":"web"}/f:image`.
+// Arrays, AtomicFields and sets of objects without a key are owned as a whole.
+type FieldSet map[string]struct{}
+
+// ManagedFields holds the fields each manager owns, by manager name.
+type ManagedFields map[string]FieldSet
+
+// Conflict is a field Apply did not change because another manager owns it with a different value.
+type Conflict struct {
+	Manager string `json:"manager"`
+	Field   string `json:"field"`
+	Path    string `json:"path"`
+}
+
+// ApplyResult is the result of Apply.
+type ApplyResult struct {
+	Plan
+	// Conflicts are the fields left as they are, they are not owned by the applying manager.
+	Conflicts []Conflict
+	// ManagedFields is the ownership after the patch has been applied.
+	ManagedFields ManagedFields
+}
+
+// Has returns true if the field is in the set.
+func (s FieldSet) Has(field string) bool {
+	_, ok := s[field]
+	return ok
+}
+
+// Fields returns the fields in the set, sorted.
+func (s FieldSet) Fields() []string {
+	return slices.Sorted(maps.Keys(s))
+}
+
+func (s FieldSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Fields())
+}
+
+func (s *FieldSet) UnmarshalJSON(data []byte) error {
+	var fields []string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*s = make(FieldSet, len(fields))
+	for _, field := range fields {
+		(*s)[field] = struct{}{}
+	}
+	return nil
+}
+
+// covers returns true if the set holds `field` or any field below it.
+func (s FieldSet) covers(field string) bool {
+	if s.Has(field) {
+		return true
+	}
+	for owned := range s {
+		if strings.HasPrefix(owned, field+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply merges the configuration `applied` of `manager` into the `live` document and returns the patch to get there,
+// like Kubernetes server side apply.
+//
+// Fields in `applied` are taken over unless another manager in `managed` owns them with a different value, those are
+// left as they are and reported as Conflicts. With PatchStrategyExactMatch, fields the manager owned before but no
+// longer applies are removed, as long as no other manager owns them. The manager ends up owning the fields it applied
+// without a conflict.
+//
+// Changes to ImmutableFields are split off the patch as they are by CreatePlan.
+func Apply(live, applied []byte, manager string, managed ManagedFields, collections Collections, strategy PatchStrategy, opts ...Option) (*ApplyResult, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Apply(live, applied, manager, managed, strategy, opts...)
+}
+
+// Apply applies like the package level Apply, using the compiled collections.
+func (c *CompiledCollections) Apply(live, applied []byte, manager string, managed ManagedFields, strategy PatchStrategy, opts ...Option) (*ApplyResult, error) {
+	o := newOptions(opts)
+	// base is the live document with the removed members taken out, merged the document it is patched into.
+	var base, merged, appliedDoc any
+	if json.Unmarshal(live, &base) != nil || json.Unmarshal(live, &merged) != nil || json.Unmarshal(applied, &appliedDoc) != nil {
+		return nil, errBadJsonDoc
+	}
+	appliedDoc, err := c.removeUndiffedFields(appliedDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &applier{collections: c, hasher: newValueHasher(c), manager: manager, managed: managed, conflicted: FieldSet{}}
+	merged = a.merge(merged, appliedDoc, "", "")
+
+	owned := FieldSet{}
+	for field := range c.fieldSet(appliedDoc) {
+		if !a.conflictedAt(field) {
+			owned[field] = struct{}{}
+		}
+	}
+
+	removals := []JsonPatchOperation{}
+	if strategy == PatchStrategyExactMatch {
+		for _, field := range managed[manager].Fields() {
+			if owned.covers(field) || len(a.owners(field)) > 0 {
+				continue
+			}
+			segments := fieldSegments(field)
+			if _, ok := a.remove(merged, "", segments, func(v any) { merged = v }); !ok {
+				continue
+			}
+			// Elements are removed by the diff below, members have to be removed explicitly.
+			if strings.HasPrefix(segments[len(segments)-1], "f:") {
+				if pointer, ok := a.remove(base, "", segments, func(v any) { base = v }); ok {
+					removals = append(removals, NewPatch("remove", pointer, nil))
+				}
+			}
+		}
+	}
+
+	if base, err = c.removeUndiffedFields(base); err != nil {
+		return nil, err
+	}
+	if merged, err = c.removeUndiffedFields(merged); err != nil {
+		return nil, err
+	}
+	base, merged = c.removeMatchingEquivalents(base, merged)
+	patch, err := handleValues(base, merged, "", removals, PatchStrategyExactMatch, c, newDiffState(c, o))
+	if err != nil {
+		return nil, err
+	}
+	plan, err := c.plan(patch, o)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ApplyResult{Plan: *plan, Conflicts: a.conflicts, ManagedFields: make(ManagedFields, len(managed)+1)}
+	if result.Conflicts == nil {
+		result.Conflicts = []Conflict{}
+	}
+	for name, fields := range managed {
+		result.ManagedFields[name] = maps.Clone(fields)
+	}
+	result.ManagedFields[manager] = owned
+	return result, nil
+}
+
+// FieldSet returns the fields set in `doc`, the fields a manager applying `doc` owns.
+func (c *CompiledCollections) FieldSet(doc []byte) (FieldSet, error) {
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, errBadJsonDoc
+	}
+	v, err := c.removeUndiffedFields(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.fieldSet(v), nil
+}
+
+func (c *CompiledCollections) fieldSet(v any) FieldSet {
+	fields := FieldSet{}
+	c.collectFields(v, "", "", fields)
+	return fields
+}
+
+func (c *CompiledCollections) collectFields(v any, p, field string, fields FieldSet) {
+	if c.isAtomic(p) {
+		fields[field] = struct{}{}
+		return
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		if len(t) == 0 && field != "" {
+			fields[field] = struct{}{}
+		}
+		for key, member := range t {
+			c.collectFields(member, makePath(p, key), memberField(field, key), fields)
+		}
+	case []any:
+		switch c.listKind(p, t, t) {
+		case collectionEntitySet:
+			key, _ := c.entitySetKey(p)
+			for i, element := range t {
+				elementField := field + "/" + rfc6901Encoder.Replace("k:"+entityField(element, key))
+				fields[elementField] = struct{}{}
+				c.collectFields(element, makePath(p, i), elementField, fields)
+			}
+		case collectionSet:
+			for _, element := range t {
+				fields[valueField(field, element)] = struct{}{}
+			}
+		default:
+			fields[field] = struct{}{}
+		}
+	default:
+		fields[field] = struct{}{}
+	}
+}
+
+// listKind returns how ownership of the elements of the lists a and b at p is tracked: per element for entity sets
+// and sets of primitives, as a whole for anything else.
+func (c *CompiledCollections) listKind(p string, a, b []any) collectionKind {
+	switch {
+	case c.isEntitySet(p) && allObjects(a) && allObjects(b):
+		return collectionEntitySet
+	case !c.isArray(p) && !c.isEntitySet(p) && allPrimitives(a) && allPrimitives(b):
+		return collectionSet
+	}
+	return collectionAtomic
+}
+
+func memberField(field, name string) string {
+	return field + "/" + rfc6901Encoder.Replace("f:"+name)
+}
+
+func valueField(field string, value any) string {
+	b, _ := json.Marshal(value)
+	return field + "/" + rfc6901Encoder.Replace("v:"+string(b))
+}
+
+// entityField returns the key fields of an entity set element as a Json object, e.g. `{"name":"web"}`.
+func entityField(element any, key Key) string {
+	values := make(map[string]any)
+	for _, field := range key.Fields() {
+		values[field] = element.(map[string]any)[field]
+	}
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+func fieldSegments(field string) []string {
+	segments := strings.Split(strings.TrimPrefix(field, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = rfc6901Decoder.Replace(segment)
+	}
+	return segments
+}
+
+// applier holds what a single Apply call shares between the nodes it merges.
+type applier struct {
+	collections *CompiledCollections
+	hasher      *valueHasher
+	manager     string
+	managed     ManagedFields
+	conflicts   []Conflict
+	conflicted  FieldSet
+}
+
+// owners returns the managers other than the applying one that own `field` or a field below it.
+func (a *applier) owners(field string) []string {
+	var owners []string
+	for _, name := range slices.Sorted(maps.Keys(a.managed)) {
+		if name != a.manager && a.managed[name].covers(field) {
+			owners = append(owners, name)
+		}
+	}
+	return owners
+}
+
+// conflictedAt returns true if `field` is, or is below, a field that was left as it is because of a conflict.
+func (a *applier) conflictedAt(field string) bool {
+	for conflicted := range a.conflicted {
+		if field == conflicted || strings.HasPrefix(field, conflicted+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// merge returns the live value lv with the applied value av merged into it.
+func (a *applier) merge(lv, av any, p, field string) any {
+	c := a.collections
+	if lv != nil && !c.isAtomic(p) {
+		switch at := av.(type) {
+		case map[string]any:
+			if lt, ok := lv.(map[string]any); ok {
+				for _, key := range slices.Sorted(maps.Keys(at)) {
+					if member, found := lt[key]; found {
+						lt[key] = a.merge(member, at[key], makePath(p, key), memberField(field, key))
+					} else {
+						lt[key] = at[key]
+					}
+				}
+				return lt
+			}
+		case []any:
+			if lt, ok := lv.([]any); ok {
+				switch c.listKind(p, lt, at) {
+				case collectionEntitySet:
+					return a.mergeEntitySet(lt, at, p, field)
+				case collectionSet:
+					index := a.hasher.index(lt, c.lookup(p).elements())
+					for _, v := range at {
+						if !index.contains(v) {
+							lt = append(lt, v)
+						}
+					}
+					return lt
+				}
+			}
+		}
+	}
+
+	if lv == nil || matchesValue(lv, av, p, !c.isArray(p) && !c.isAtomic(p), a.hasher) {
+		return av
+	}
+	if owners := a.owners(field); len(owners) > 0 {
+		for _, owner := range owners {
+			a.conflicts = append(a.conflicts, Conflict{Manager: owner, Field: field, Path: p})
+		}
+		a.conflicted[field] = struct{}{}
+		return lv
+	}
+	return av
+}
+
+func (a *applier) mergeEntitySet(lt, at []any, p, field string) []any {
+	key, _ := a.collections.entitySetKey(p)
+	indexes := make(map[string]int, len(lt))
+	for i, v := range lt {
+		if identity, err := entityIdentity(v, key); err == nil {
+			indexes[identity] = i
+		}
+	}
+	for _, v := range at {
+		identity, err := entityIdentity(v, key)
+		i, found := indexes[identity]
+		if err != nil || !found {
+			lt = append(lt, v)
+			continue
+		}
+		elementField := field + "/" + rfc6901Encoder.Replace("k:"+entityField(v, key))
+		lt[i] = a.merge(lt[i], v, makePath(p, i), elementField)
+	}
+	return lt
+}
+
+// remove removes the field made up of `segments` from node, which is stored with set, and returns its Json pointer.
+func (a *applier) remove(node any, p string, segments []string, set func(any)) (string, bool) {
+	segment, last := segments[0], len(segments) == 1
+	if name, ok := strings.CutPrefix(segment, "f:"); ok {
+		t, ok := node.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		member, found := t[name]
+		if !found {
+			return "", false
+		}
+		if last {
+			delete(t, name)
+			return makePath(p, name), true
+		}
+		return a.remove(member, makePath(p, name), segments[1:], func(v any) { t[name] = v })
+	}
+
+	t, ok := node.([]any)
+	if !ok {
+		return "", false
+	}
+	i := a.findElement(t, p, segment)
+	if i < 0 {
+		return "", false
+	}
+	if last {
+		set(slices.Delete(t, i, i+1))
+		return makePath(p, i), true
+	}
+	return a.remove(t[i], makePath(p, i), segments[1:], func(v any) { t[i] = v })
+}
+
+// findElement returns the index of the element of t the `k:` or `v:` segment refers to, or -1.
+func (a *applier) findElement(t []any, p, segment string) int {
+	kind, value := segment[:min(2, len(segment))], segment[min(2, len(segment)):]
+	for i, element := range t {
+		switch kind {
+		case "k:":
+			key, ok := a.collections.entitySetKey(p)
+			if _, isObject := element.(map[string]any); ok && isObject && entityField(element, key) == value {
+				return i
+			}
+		case "v:":
+			if b, _ := json.Marshal(element); string(b) == value {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,204 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Matchers stand in for a literal value in the desired document, for drift checks where the exact value is not known
+// or does not matter:
+//
+//   - `{"$any": true}`: any value, as long as the field is present
+//   - `{"$match": "^arn:aws:iam::"}`: a string matching the regular expression
+//   - `{"$range": {"min": 1, "max": 10}}`: a number within the inclusive bounds, either of which may be left out
+//
+// A field whose actual value satisfies the matcher is in sync. A field that does not is reported as a Mismatch by
+// CreatePlan, no patch operation can be generated for it. Matchers are recognised in object members and in the
+// elements of Arrays, not as elements of sets or as entity set keys.
+const (
+	AnyMatcher   = "$any"
+	RegexMatcher = "$match"
+	RangeMatcher = "$range"
+)
+
+// Mismatch is a value in the actual document that does not satisfy the matcher in the desired document.
+type Mismatch struct {
+	Path    string `json:"path"`
+	Matcher any    `json:"matcher"`
+	// Value is the actual value, nil if Found is false.
+	Value any  `json:"value,omitempty"`
+	Found bool `json:"found"`
+}
+
+type matcher struct {
+	kind     string
+	regex    *regexp.Regexp
+	min, max *float64
+}
+
+// parseMatcher returns the matcher v describes, false if v is not a matcher, or an error if it is an invalid one.
+func parseMatcher(v any) (*matcher, bool, error) {
+	t, ok := v.(map[string]any)
+	if !ok || len(t) != 1 {
+		return nil, false, nil
+	}
+	for kind, arg := range t {
+		switch kind {
+		case AnyMatcher:
+			if arg != true {
+				return nil, true, fmt.Errorf("%s must be true, got %v", AnyMatcher, arg)
+			}
+			return &matcher{kind: kind}, true, nil
+		case RegexMatcher:
+			pattern, ok := arg.(string)
+			if !ok {
+				return nil, true, fmt.Errorf("%s must be a regular expression, got %v", RegexMatcher, arg)
+			}
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, true, fmt.Errorf("%s: %w", RegexMatcher, err)
+			}
+			return &matcher{kind: kind, regex: regex}, true, nil
+		case RangeMatcher:
+			bounds, ok := arg.(map[string]any)
+			if !ok {
+				return nil, true, fmt.Errorf("%s must be an object with min and/or max, got %v", RangeMatcher, arg)
+			}
+			m := &matcher{kind: kind}
+			for bound, value := range bounds {
+				number, ok := value.(float64)
+				if !ok || (bound != "min" && bound != "max") {
+					return nil, true, fmt.Errorf("%s must be an object with min and/or max, got %v", RangeMatcher, arg)
+				}
+				if bound == "min" {
+					m.min = &number
+				} else {
+					m.max = &number
+				}
+			}
+			return m, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// matches returns true if the actual value v, found or missing, satisfies the matcher.
+func (m *matcher) matches(v any, found bool) bool {
+	if !found {
+		return false
+	}
+	switch m.kind {
+	case RegexMatcher:
+		s, ok := v.(string)
+		return ok && m.regex.MatchString(s)
+	case RangeMatcher:
+		f, ok := v.(float64)
+		return ok && (m.min == nil || f >= *m.min) && (m.max == nil || f <= *m.max)
+	}
+	return true
+}
+
+// checkMatcher checks the actual value av at p against bv if bv is a matcher, and returns false if it is not.
+func (s *diffState) checkMatcher(av any, found bool, bv any, p string) (bool, error) {
+	m, ok, err := parseMatcher(bv)
+	if err != nil {
+		return true, fmt.Errorf("invalid matcher at %s: %w", p, err)
+	}
+	if ok && !m.matches(av, found) {
+		s.mismatch(Mismatch{Path: p, Matcher: bv, Value: av, Found: found})
+	}
+	return ok, nil
+}
+
+func (s *diffState) mismatch(m Mismatch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mismatches = append(s.mismatches, m)
+}
+
+// sortedMismatches returns the mismatches ordered by path, whatever order the workers found them in.
+func (s *diffState) sortedMismatches() []Mismatch {
+	mismatches := slices.Clone(s.mismatches)
+	if mismatches == nil {
+		mismatches = []Mismatch{}
+	}
+	slices.SortStableFunc(mismatches, func(a, b Mismatch) int { return strings.Compare(a.Path, b.Path) })
+	return mismatches
+}
+
+// withoutMatchersInValues removes the matchers from the values of the operations, since they can not be added, and
+// reports each of them as a Mismatch for a missing value.
+func withoutMatchersInValues(patch []JsonPatchOperation, s *diffState) ([]JsonPatchOperation, error) {
+	filtered := patch[:0]
+	for _, op := range patch {
+		value, keep, err := s.withoutMatchers(op.Value, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			op.Value = value
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered, nil
+}
+
+// withoutMatchers returns v without the matchers in it, and false if v is a matcher itself.
+func (s *diffState) withoutMatchers(v any, p string) (any, bool, error) {
+	if isMatcher, err := s.checkMatcher(nil, false, v, p); isMatcher || err != nil {
+		return nil, false, err
+	}
+	if !containsMatcher(v) {
+		return v, true, nil
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(t))
+		for key, member := range t {
+			value, keep, err := s.withoutMatchers(member, makePath(p, key))
+			if err != nil {
+				return nil, false, err
+			}
+			if keep {
+				copied[key] = value
+			}
+		}
+		return copied, true, nil
+	case []any:
+		copied := make([]any, 0, len(t))
+		for i, element := range t {
+			value, keep, err := s.withoutMatchers(element, makePath(p, i))
+			if err != nil {
+				return nil, false, err
+			}
+			if keep {
+				copied = append(copied, value)
+			}
+		}
+		return copied, true, nil
+	}
+	return v, true, nil
+}
+
+func containsMatcher(v any) bool {
+	if _, ok, err := parseMatcher(v); ok || err != nil {
+		return true
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		for _, member := range t {
+			if containsMatcher(member) {
+				return true
+			}
+		}
+	case []any:
+		for _, element := range t {
+			if containsMatcher(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package jsonpatch
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+)
+
+// Normalize returns the canonical encoding of `doc` under the collections: object members in key order, the elements
+// of entity sets ordered by their key, the elements of other sets by their encoding, and the IgnoredFields left out.
+// Arrays keep their order. Documents that are Equal normalize to the same bytes.
+func Normalize(doc []byte, collections Collections) ([]byte, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Normalize(doc)
+}
+
+// Normalize normalizes the document like the package level Normalize, using the compiled collections.
+func (c *CompiledCollections) Normalize(doc []byte) ([]byte, error) {
+	withoutIgnoredFields, err := c.unmarshalDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := normalize(withoutIgnoredFields, c.root)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalized)
+}
+
+// normalize returns `v`, whose path compiled to `path`, with the elements of all sets below it in canonical order and
+// -0 turned into 0. encoding/json already writes object members in key order.
+func normalize(v any, path *pathNode) (any, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(t))
+		for k, member := range t {
+			value, err := normalize(member, path.member(k))
+			if err != nil {
+				return nil, err
+			}
+			normalized[k] = value
+		}
+		return normalized, nil
+	case []any:
+		elementPath := path.elements()
+		normalized := make([]any, len(t))
+		for i, element := range t {
+			value, err := normalize(element, elementPath)
+			if err != nil {
+				return nil, err
+			}
+			normalized[i] = value
+		}
+		if path.isArray() {
+			return normalized, nil
+		}
+		return sortSet(normalized, path)
+	case float64:
+		if t == 0 {
+			return 0.0, nil // fold -0 into 0, they are Equal
+		}
+	}
+	return v, nil
+}
+
+// sortSet orders the normalized elements of the set at path, by key for entity sets and then by their encoding.
+func sortSet(elements []any, path *pathNode) ([]any, error) {
+	type sortable struct {
+		identity, encoding string
+		value              any
+	}
+	sorted := make([]sortable, len(elements))
+	for i, element := range elements {
+		encoding, err := json.Marshal(element)
+		if err != nil {
+			return nil, err
+		}
+		sorted[i] = sortable{encoding: string(encoding), value: element}
+		if _, isObject := element.(map[string]any); isObject && path.isEntitySet() {
+			if sorted[i].identity, err = entityIdentity(element, path.key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	slices.SortFunc(sorted, func(a, b sortable) int {
+		return cmp.Or(cmp.Compare(a.identity, b.identity), cmp.Compare(a.encoding, b.encoding))
+	})
+	for i := range sorted {
+		elements[i] = sorted[i].value
+	}
+	return elements, nil
+}
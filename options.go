@@ -0,0 +1,95 @@
+package jsonpatch
+
+import (
+	"sync"
+)
+
+// Option changes how CreatePatch computes a patch.
+type Option func(*options)
+
+type options struct {
+	concurrency           int
+	strictImmutableFields bool
+}
+
+// WithConcurrency diffs independent subtrees (the members of an object and the matched elements of an entity set) on
+// up to `workers` goroutines. The resulting patch is identical to the one computed sequentially.
+// A value below 2 keeps the diff on the calling goroutine.
+func WithConcurrency(workers int) Option {
+	return func(o *options) {
+		o.concurrency = workers
+	}
+}
+
+// WithStrictImmutableFields makes CreatePatch and CreatePlan return an *ImmutableFieldError when the documents differ
+// in any of the ImmutableFields, instead of leaving those changes out of the patch.
+func WithStrictImmutableFields() Option {
+	return func(o *options) {
+		o.strictImmutableFields = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// diffState holds what a single CreatePatch call shares between all the nodes it visits.
+type diffState struct {
+	hasher     *valueHasher
+	pool       *workerPool
+	mu         sync.Mutex // guards mismatches
+	mismatches []Mismatch
+	// firstDrift stops the diff at the first difference, drifted is set once it is found.
+	firstDrift, drifted bool
+}
+
+func newDiffState(collections *CompiledCollections, o options) *diffState {
+	s := &diffState{hasher: newValueHasher(collections)}
+	if o.concurrency > 1 {
+		s.pool = newWorkerPool(o.concurrency)
+		s.hasher.mu = &sync.Mutex{}
+	}
+	return s
+}
+
+// workerPool bounds the number of extra goroutines a single diff uses.
+type workerPool struct {
+	slots chan struct{}
+}
+
+func newWorkerPool(workers int) *workerPool {
+	// The calling goroutine does work too, so it takes up one of the workers.
+	return &workerPool{slots: make(chan struct{}, workers-1)}
+}
+
+// run calls task for 0..n-1 and returns when all calls have returned. A task runs on its own goroutine when a worker is
+// free and on the calling goroutine otherwise, so nested calls to run can never deadlock waiting for a worker.
+func (p *workerPool) run(n int, task func(i int)) {
+	if p == nil || n < 2 {
+		for i := range n {
+			task(i)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for i := range n {
+		select {
+		case p.slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-p.slots
+					wg.Done()
+				}()
+				task(i)
+			}()
+		default:
+			task(i)
+		}
+	}
+	wg.Wait()
+}
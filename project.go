@@ -0,0 +1,92 @@
+package jsonpatch
+
+import "encoding/json"
+
+// Project returns the `actual` document restricted to the shape of a partial desired document: only the object members
+// that are also in `shape` are kept. Entity set elements are matched on their key and follow the order of `shape`,
+// Arrays are projected position by position. Sets, AtomicFields, scalars and values whose type differs from the one in
+// `shape` are kept as a whole, as are the values `shape` has a matcher or a tombstone for. Members and elements that
+// are missing in `actual` are left out.
+func Project(actual, shape []byte, collections Collections) ([]byte, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Project(actual, shape)
+}
+
+// Project projects the document like the package level Project, using the compiled collections.
+func (c *CompiledCollections) Project(actual, shape []byte) ([]byte, error) {
+	actualWithoutIgnoredFields, shapeWithoutIgnoredFields, err := c.unmarshalDocuments(actual, shape)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(project(actualWithoutIgnoredFields, shapeWithoutIgnoredFields, c.root))
+}
+
+// project returns the parts of v that are in shape, for the path that compiled to node.
+func project(v, shape any, node *pathNode) any {
+	if node != nil && node.atomic || isTombstone(shape) {
+		return v
+	}
+	if _, isMatcher, _ := parseMatcher(shape); isMatcher {
+		return v
+	}
+	switch st := shape.(type) {
+	case map[string]any:
+		vt, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		projected := make(map[string]any, len(st))
+		for key, member := range st {
+			if value, ok := vt[key]; ok {
+				projected[key] = project(value, member, node.member(key))
+			}
+		}
+		return projected
+	case []any:
+		vt, ok := v.([]any)
+		if !ok {
+			return v
+		}
+		switch {
+		case node.isArray():
+			projected := make([]any, 0, len(st))
+			for i := 0; i < len(st) && i < len(vt); i++ {
+				projected = append(projected, project(vt[i], st[i], node.elements()))
+			}
+			return projected
+		case node.isEntitySet():
+			return projectEntities(vt, st, node)
+		}
+	}
+	return v
+}
+
+// projectEntities returns the elements of v with the keys of the elements of shape, each projected onto its shape.
+func projectEntities(v, shape []any, node *pathNode) []any {
+	lookup := make(map[string]any, len(v))
+	for _, element := range v {
+		if _, ok := element.(map[string]any); !ok {
+			continue
+		}
+		if identity, err := entityIdentity(element, node.key); err == nil {
+			lookup[identity] = element
+		}
+	}
+	projected := make([]any, 0, len(shape))
+	for _, element := range shape {
+		if _, ok := element.(map[string]any); !ok {
+			continue
+		}
+		identity, err := entityIdentity(element, node.key)
+		if err != nil {
+			continue
+		}
+		if actual, ok := lookup[identity]; ok {
+			projected = append(projected, project(actual, element, node.elements()))
+		}
+	}
+	return projected
+}
@@ -0,0 +1,143 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Reconciliation is the result of Reconcile.
+type Reconciliation struct {
+	Plan
+	// Result is the actual document with the patch applied.
+	Result []byte
+}
+
+// Reconcile diffs the `actual` document against the `desired` one like CreatePlan, applies the patch to `actual` and
+// returns both. This is useful with PatchStrategyEnsureExists, where `desired` only holds what must be present and the
+// resulting document can not be derived from it.
+//
+// Diffing the result against `desired` yields no further changes, besides the ones in RequiresReplacement.
+func Reconcile(actual, desired []byte, collections Collections, strategy PatchStrategy, opts ...Option) (*Reconciliation, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Reconcile(actual, desired, strategy, opts...)
+}
+
+// Reconcile reconciles like the package level Reconcile, using the compiled collections.
+func (c *CompiledCollections) Reconcile(actual, desired []byte, strategy PatchStrategy, opts ...Option) (*Reconciliation, error) {
+	plan, err := c.CreatePlan(actual, desired, strategy, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(actual, &doc); err != nil {
+		return nil, errBadJsonDoc
+	}
+	for _, op := range plan.Patch {
+		if doc, err = applyOperation(doc, op); err != nil {
+			return nil, fmt.Errorf("error applying %s: %w", op.Json(), err)
+		}
+	}
+	result, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Reconciliation{Plan: *plan, Result: result}, nil
+}
+
+// applyOperation applies a single add, remove or replace operation to doc and returns the changed document. The value
+// of op is copied, so later changes to doc do not change the patch.
+func applyOperation(doc any, op JsonPatchOperation) (any, error) {
+	op.Value = deepCopy(op.Value)
+	if op.Path == "" {
+		switch op.Operation {
+		case "add", "replace":
+			return op.Value, nil
+		}
+		return nil, fmt.Errorf("cannot %s the whole document", op.Operation)
+	}
+	tokens := strings.Split(strings.TrimPrefix(op.Path, "/"), "/")
+	for i, token := range tokens {
+		tokens[i] = rfc6901Decoder.Replace(token)
+	}
+	return applyAt(doc, tokens, op)
+}
+
+// applyAt applies op to the node at `tokens` below node and returns the changed node.
+func applyAt(node any, tokens []string, op JsonPatchOperation) (any, error) {
+	token, last := tokens[0], len(tokens) == 1
+	switch t := node.(type) {
+	case map[string]any:
+		child, found := t[token]
+		if !last {
+			if !found {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			changed, err := applyAt(child, tokens[1:], op)
+			t[token] = changed
+			return t, err
+		}
+		switch {
+		case op.Operation == "add":
+			t[token] = op.Value
+		case !found:
+			return nil, fmt.Errorf("member %q does not exist", token)
+		case op.Operation == "replace":
+			t[token] = op.Value
+		case op.Operation == "remove":
+			delete(t, token)
+		default:
+			return nil, fmt.Errorf("unsupported operation %q", op.Operation)
+		}
+		return t, nil
+	case []any:
+		if last && op.Operation == "add" && token == "-" {
+			return append(t, op.Value), nil
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > len(t) || (i == len(t) && !(last && op.Operation == "add")) {
+			return nil, fmt.Errorf("index %q is out of bounds", token)
+		}
+		if !last {
+			changed, err := applyAt(t[i], tokens[1:], op)
+			t[i] = changed
+			return t, err
+		}
+		switch op.Operation {
+		case "add":
+			return slices.Insert(t, i, op.Value), nil
+		case "replace":
+			t[i] = op.Value
+		case "remove":
+			return slices.Delete(t, i, i+1), nil
+		default:
+			return nil, fmt.Errorf("unsupported operation %q", op.Operation)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("cannot apply below a %T", node)
+}
+
+// deepCopy returns a copy of the decoded Json value v that shares no objects or arrays with it.
+func deepCopy(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(t))
+		for key, member := range t {
+			copied[key] = deepCopy(member)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(t))
+		for i, element := range t {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	}
+	return v
+}
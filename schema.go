@@ -0,0 +1,259 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// IdentityKeyword is the custom Json Schema keyword that marks an array as an entity set. Its value is the name of
+// the property that identifies the elements, e.g. `"x-identity": "id"`. It can be put on the array or on its items.
+const IdentityKeyword = "x-identity"
+
+// CollectionsFromJsonSchema derives Collections from a Json Schema, so the diff rules cannot drift from the schema.
+//
+//   - arrays marked with IdentityKeyword become entity sets keyed on the named property
+//   - arrays with `uniqueItems: true` become sets
+//   - any other array keeps its order and becomes one of the Arrays
+//   - `readOnly` properties become IgnoredFields
+//   - the `default` of a property becomes one of the Defaults
+//
+// Local `$ref`s (`#/definitions/...`, `#/$defs/...`), nested `items` and the subschemas of `allOf`, `anyOf` and
+// `oneOf` are followed. A recursive `$ref` is not followed into itself again, a finite list of paths cannot describe
+// the unbounded levels below it.
+func CollectionsFromJsonSchema(schema []byte) (Collections, error) {
+	var root any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return Collections{}, errBadJsonDoc
+	}
+	w := &schemaWalker{root: root, classify: classifyJsonSchemaArray, entitySets: EntitySets{}}
+	if err := w.walk(root, "$"); err != nil {
+		return Collections{}, err
+	}
+	return w.collections()
+}
+
+type schemaWalker struct {
+	root     any
+	refs     []string // the $refs being followed, to stop at recursive references
+	classify func(w *schemaWalker, array map[string]any, items any) (collectionKind, Key, error)
+	// atomicObject reports whether an object schema is replaced as a whole, it is nil if objects never are.
+	atomicObject  func(object map[string]any) bool
+	entitySets    EntitySets
+	arrays        []Path
+	ignoredFields []Path
+	atomicFields  []Path
+	defaults      map[Path]any
+}
+
+// collectionKind is the kind of collection an array schema describes.
+type collectionKind int
+
+const (
+	collectionSet collectionKind = iota
+	collectionArray
+	collectionEntitySet
+	collectionAtomic
+)
+
+func (w *schemaWalker) walk(schema any, path string) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil // boolean schemas don't describe any collections
+	}
+
+	if ref, ok := s["$ref"].(string); ok && !slices.Contains(w.refs, ref) {
+		target, err := resolveSchemaRef(w.root, ref)
+		if err != nil {
+			return err
+		}
+		w.refs = append(w.refs, ref)
+		err = w.walk(target, path)
+		w.refs = w.refs[:len(w.refs)-1]
+		if err != nil {
+			return err
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subschemas, _ := s[keyword].([]any)
+		for _, subschema := range subschemas {
+			if err := w.walk(subschema, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if readOnly, _ := s["readOnly"].(bool); readOnly && path != "$" {
+		// The whole subtree is ignored, there is nothing left to describe below it. Ignoring every element of an array
+		// means ignoring the array.
+		ignored := path
+		for strings.HasSuffix(ignored, "[*]") {
+			ignored = strings.TrimSuffix(ignored, "[*]")
+		}
+		if ignored != "$" {
+			w.ignoredFields = append(w.ignoredFields, Path(ignored))
+		}
+		return nil
+	}
+	if value, ok := s["default"]; ok && path != "$" && !strings.HasSuffix(path, "[*]") {
+		if w.defaults == nil {
+			w.defaults = make(map[Path]any)
+		}
+		w.defaults[Path(path)] = value
+	}
+	if w.atomicObject != nil && w.atomicObject(s) && path != "$" {
+		w.atomicFields = append(w.atomicFields, Path(path))
+		return nil
+	}
+
+	if properties, ok := s["properties"].(map[string]any); ok {
+		for name, property := range properties {
+			if strings.ContainsAny(name, ".[") {
+				return fmt.Errorf("property %q at %s cannot be expressed in a Json path", name, path)
+			}
+			if err := w.walk(property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	items, hasItems := s["items"]
+	if !hasItems && !schemaHasType(s, "array") {
+		return nil
+	}
+	kind, key, err := w.classify(w, s, items)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	switch kind {
+	case collectionEntitySet:
+		w.entitySets[Path(path)] = key
+	case collectionArray:
+		w.arrays = append(w.arrays, Path(path))
+	case collectionAtomic:
+		// The array is replaced as a whole, nothing below it is diffed.
+		w.atomicFields = append(w.atomicFields, Path(path))
+		return nil
+	case collectionSet:
+		// Sets are the default for arrays that are not declared otherwise.
+	}
+	return w.walk(items, path+"[*]")
+}
+
+// classifyJsonSchemaArray classifies an array of a plain Json Schema.
+func classifyJsonSchemaArray(w *schemaWalker, array map[string]any, items any) (collectionKind, Key, error) {
+	return w.classifyByIdentity(array, items, uniqueItems)
+}
+
+// classifyByIdentity returns an entity set for arrays marked with IdentityKeyword, a set for arrays `unordered` accepts
+// and an array otherwise.
+func (w *schemaWalker) classifyByIdentity(array map[string]any, items any, unordered func(array map[string]any) bool) (collectionKind, Key, error) {
+	key, err := w.identity(array, items)
+	switch {
+	case err != nil:
+		return 0, "", fmt.Errorf("%s %w", IdentityKeyword, err)
+	case key != "":
+		return collectionEntitySet, key, nil
+	case unordered(array):
+		return collectionSet, "", nil
+	}
+	return collectionArray, "", nil
+}
+
+// identity returns the entity set key declared on an array schema or on its items, following a `$ref` in items.
+func (w *schemaWalker) identity(array map[string]any, items any) (Key, error) {
+	candidates := []any{array[IdentityKeyword]}
+	if s, ok := items.(map[string]any); ok {
+		candidates = append(candidates, s[IdentityKeyword])
+		if ref, ok := s["$ref"].(string); ok {
+			if target, err := resolveSchemaRef(w.root, ref); err == nil {
+				if t, ok := target.(map[string]any); ok {
+					candidates = append(candidates, t[IdentityKeyword])
+				}
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		switch key := candidate.(type) {
+		case nil:
+		case string:
+			if key == "" {
+				return "", fmt.Errorf("must name a property")
+			}
+			return Key(key), nil
+		default:
+			return "", fmt.Errorf("must be a property name, got %v", candidate)
+		}
+	}
+	return "", nil
+}
+
+// uniqueItems reports whether an array schema describes a set, for plain Json Schemas.
+func uniqueItems(array map[string]any) bool {
+	unique, _ := array["uniqueItems"].(bool)
+	return unique
+}
+
+func (w *schemaWalker) collections() (Collections, error) {
+	collections := Collections{
+		EntitySets:    w.entitySets,
+		Arrays:        compactPaths(w.arrays),
+		IgnoredFields: compactPaths(w.ignoredFields),
+	}
+	if len(w.atomicFields) > 0 {
+		collections.AtomicFields = compactPaths(w.atomicFields)
+	}
+	if len(w.defaults) > 0 {
+		collections.Defaults = w.defaults
+	}
+	if _, err := collections.Compile(); err != nil {
+		return Collections{}, fmt.Errorf("schema describes invalid collections: %w", err)
+	}
+	return collections, nil
+}
+
+func compactPaths(paths []Path) []Path {
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+func schemaHasType(schema map[string]any, typ string) bool {
+	switch t := schema["type"].(type) {
+	case string:
+		return t == typ
+	case []any:
+		return slices.Contains(t, any(typ))
+	}
+	return false
+}
+
+// resolveSchemaRef resolves a `$ref` that points into the schema itself, like `#/definitions/tag`.
+func resolveSchemaRef(root any, ref string) (any, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q: only references within the schema are supported", ref)
+	}
+	node := root
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		token = rfc6901Decoder.Replace(token)
+		switch t := node.(type) {
+		case map[string]any:
+			child, ok := t[token]
+			if !ok {
+				return nil, fmt.Errorf("unresolvable $ref %q", ref)
+			}
+			node = child
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, fmt.Errorf("unresolvable $ref %q", ref)
+			}
+			node = t[i]
+		default:
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	return node, nil
+}
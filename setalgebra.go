@@ -0,0 +1,239 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// SetResult is the result of Union, Intersect and Subtract.
+type SetResult struct {
+	Document []byte
+	// Conflicts are the values that are in both documents, but differ. The document holds the value of `a` for them,
+	// Intersect leaves them out.
+	Conflicts []ValueConflict
+}
+
+// ValueConflict is a value that differs between the documents `a` and `b`.
+type ValueConflict struct {
+	Path string `json:"path"`
+	A    any    `json:"a"`
+	B    any    `json:"b"`
+}
+
+type setOperation int
+
+const (
+	setUnion setOperation = iota
+	setIntersect
+	setSubtract
+)
+
+// Union returns everything that is in `a` or `b`. Objects are merged member by member, sets hold the elements of both
+// and entity set elements with the same key are merged. Scalars, Arrays and AtomicFields are values of their own, when
+// they differ the one of `a` is kept.
+func Union(a, b []byte, collections Collections) (*SetResult, error) {
+	return combineDocuments(a, b, collections, setUnion)
+}
+
+// Intersect returns everything that is in both `a` and `b`: the members both objects have, the elements both sets
+// have and the entity set elements with a key both have, intersected in turn. Values that differ are left out.
+func Intersect(a, b []byte, collections Collections) (*SetResult, error) {
+	return combineDocuments(a, b, collections, setIntersect)
+}
+
+// Subtract returns everything in `a` that is not in `b`, e.g. everything that was added to a baseline. Members and
+// elements that are equal in both are left out, as are objects and sets that end up empty. Entity set elements keep
+// their key fields. Values that differ are kept as they are in `a`.
+func Subtract(a, b []byte, collections Collections) (*SetResult, error) {
+	return combineDocuments(a, b, collections, setSubtract)
+}
+
+// Union combines the documents like the package level Union, using the compiled collections.
+func (c *CompiledCollections) Union(a, b []byte) (*SetResult, error) {
+	return c.combineDocuments(a, b, setUnion)
+}
+
+// Intersect combines the documents like the package level Intersect, using the compiled collections.
+func (c *CompiledCollections) Intersect(a, b []byte) (*SetResult, error) {
+	return c.combineDocuments(a, b, setIntersect)
+}
+
+// Subtract combines the documents like the package level Subtract, using the compiled collections.
+func (c *CompiledCollections) Subtract(a, b []byte) (*SetResult, error) {
+	return c.combineDocuments(a, b, setSubtract)
+}
+
+func combineDocuments(a, b []byte, collections Collections, op setOperation) (*SetResult, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.combineDocuments(a, b, op)
+}
+
+func (c *CompiledCollections) combineDocuments(a, b []byte, op setOperation) (*SetResult, error) {
+	aWithoutIgnoredFields, bWithoutIgnoredFields, err := c.unmarshalDocuments(a, b)
+	if err != nil {
+		return nil, err
+	}
+	s := &setAlgebra{op: op, hasher: newValueHasher(c), conflicts: []ValueConflict{}}
+	combined, keep := s.combine(aWithoutIgnoredFields, bWithoutIgnoredFields, "", c.root)
+	if !keep {
+		// Nothing is left of the whole document, keep its type.
+		switch aWithoutIgnoredFields.(type) {
+		case map[string]any:
+			combined = map[string]any{}
+		case []any:
+			combined = []any{}
+		}
+	}
+	document, err := json.Marshal(combined)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(s.conflicts, func(a, b ValueConflict) int { return strings.Compare(a.Path, b.Path) })
+	return &SetResult{Document: document, Conflicts: s.conflicts}, nil
+}
+
+type setAlgebra struct {
+	op        setOperation
+	hasher    *valueHasher
+	conflicts []ValueConflict
+}
+
+// combine returns the combination of `a` and `b` at p, whose path compiled to node, and false if nothing is left of it.
+func (s *setAlgebra) combine(a, b any, p string, node *pathNode) (any, bool) {
+	if node == nil || !node.atomic {
+		switch at := a.(type) {
+		case map[string]any:
+			if bt, ok := b.(map[string]any); ok {
+				return s.combineObjects(at, bt, p, node)
+			}
+		case []any:
+			if bt, ok := b.([]any); ok && !node.isArray() {
+				if node.isEntitySet() {
+					return s.combineEntities(at, bt, p, node)
+				}
+				return s.combineSets(at, bt, node)
+			}
+		}
+	}
+
+	equal := s.hasher.equal(a, b, node)
+	if !equal {
+		s.conflicts = append(s.conflicts, ValueConflict{Path: p, A: a, B: b})
+	}
+	switch s.op {
+	case setIntersect:
+		return a, equal
+	case setSubtract:
+		return a, !equal
+	}
+	return a, true
+}
+
+func (s *setAlgebra) combineObjects(a, b map[string]any, p string, node *pathNode) (any, bool) {
+	combined := make(map[string]any, len(a))
+	for _, key := range slices.Sorted(maps.Keys(a)) {
+		bv, ok := b[key]
+		switch {
+		case ok:
+			if value, keep := s.combine(a[key], bv, makePath(p, key), node.member(key)); keep {
+				combined[key] = value
+			}
+		case s.op != setIntersect:
+			combined[key] = a[key]
+		}
+	}
+	if s.op == setUnion {
+		for key, bv := range b {
+			if _, ok := a[key]; !ok {
+				combined[key] = bv
+			}
+		}
+	}
+	return combined, s.op != setSubtract || len(combined) > 0
+}
+
+func (s *setAlgebra) combineSets(a, b []any, node *pathNode) (any, bool) {
+	elementPath := node.elements()
+	combined := make([]any, 0, len(a))
+	inB := s.hasher.index(b, elementPath)
+	for _, v := range a {
+		if inB.contains(v) == (s.op != setSubtract) || s.op == setUnion {
+			combined = append(combined, v)
+		}
+	}
+	if s.op == setUnion {
+		inA := s.hasher.index(a, elementPath)
+		for _, v := range b {
+			if !inA.contains(v) {
+				combined = append(combined, v)
+			}
+		}
+	}
+	return combined, s.op != setSubtract || len(combined) > 0
+}
+
+func (s *setAlgebra) combineEntities(a, b []any, p string, node *pathNode) (any, bool) {
+	elementPath := node.elements()
+	fields := node.key.Fields()
+	identity := func(v any) (string, bool) {
+		if _, ok := v.(map[string]any); !ok {
+			return "", false
+		}
+		id, err := entityIdentity(v, node.key)
+		return id, err == nil
+	}
+
+	lookup := make(map[string]int, len(b))
+	for i, v := range b {
+		if id, ok := identity(v); ok {
+			lookup[id] = i
+		}
+	}
+	matched := make(map[int]struct{}, len(b))
+	combined := make([]any, 0, len(a))
+	for i, v := range a {
+		id, ok := identity(v)
+		index, found := lookup[id]
+		if !ok || !found {
+			// Elements without a key only match equal elements.
+			if !ok {
+				index = slices.IndexFunc(b, func(w any) bool { return s.hasher.equal(v, w, elementPath) })
+				found = index >= 0
+			}
+			if found == (s.op != setSubtract) || s.op == setUnion {
+				combined = append(combined, v)
+			}
+			if found {
+				matched[index] = struct{}{}
+			}
+			continue
+		}
+		matched[index] = struct{}{}
+		value, keep := s.combine(v, b[index], makePath(p, i), elementPath)
+		if !keep {
+			continue
+		}
+		if element, isObject := value.(map[string]any); isObject && s.op == setSubtract {
+			// The element has to stay identifiable, even if its key fields are equal in both.
+			for _, field := range fields {
+				if kv, ok := v.(map[string]any)[field]; ok {
+					element[field] = kv
+				}
+			}
+		}
+		combined = append(combined, value)
+	}
+	if s.op == setUnion {
+		for i, v := range b {
+			if _, ok := matched[i]; !ok {
+				combined = append(combined, v)
+			}
+		}
+	}
+	return combined, s.op != setSubtract || len(combined) > 0
+}
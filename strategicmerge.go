@@ -0,0 +1,240 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Directives of the Kubernetes strategic merge patch format.
+const (
+	smpPatchDirective          = "$patch"
+	smpSetElementOrderPrefix   = "$setElementOrder/"
+	smpDeleteFromPrimitiveList = "$deleteFromPrimitiveList/"
+)
+
+// CreateStrategicMergePatch creates a Kubernetes strategic merge patch from the same inputs as CreatePatch.
+//
+// The key of an entity set is used as its merge key: changed elements are sent with their merge key, new elements as a
+// whole and, with PatchStrategyExactMatch, elements that are gone as `$patch: delete`. Sets of primitives are merged,
+// with removed values listed in `$deleteFromPrimitiveList`. With PatchStrategyExactMatch the order of a merged list
+// that changed is sent in `$setElementOrder`, a list that was only reordered is not a change, like in CreatePatch.
+// Arrays, AtomicFields and sets of objects without a key are replaced as a whole, entity sets with a CompositeKey too,
+// with a `$patch: replace` directive, since a strategic merge patch has a single merge key.
+//
+// Like CreatePatch, properties missing from `b` are not deleted unless they are marked with DeleteDirective, and
+// changes to ImmutableFields are left out or, with WithStrictImmutableFields, returned as an *ImmutableFieldError.
+// Both documents must be Json objects.
+func CreateStrategicMergePatch(a, b []byte, collections Collections, strategy PatchStrategy, opts ...Option) ([]byte, error) {
+	compiled, err := collections.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.CreateStrategicMergePatch(a, b, strategy, opts...)
+}
+
+// CreateStrategicMergePatch creates a strategic merge patch like the package level CreateStrategicMergePatch, using the
+// compiled collections.
+func (c *CompiledCollections) CreateStrategicMergePatch(a, b []byte, strategy PatchStrategy, opts ...Option) ([]byte, error) {
+	o := newOptions(opts)
+	av, bv, err := c.unmarshalDiffedDocuments(a, b)
+	if err != nil {
+		return nil, err
+	}
+	at, aIsObject := av.(map[string]any)
+	bt, bIsObject := bv.(map[string]any)
+	if !aIsObject || !bIsObject {
+		return nil, fmt.Errorf("a strategic merge patch needs two Json objects")
+	}
+
+	d := &smpDiffer{collections: c, strategy: strategy, hasher: newValueHasher(c)}
+	patch := withoutTombstones(d.object(at, bt, ""))
+	if o.strictImmutableFields && len(d.requiresReplacement) > 0 {
+		return nil, &ImmutableFieldError{Operations: d.requiresReplacement}
+	}
+	return json.Marshal(patch)
+}
+
+type smpDiffer struct {
+	collections         *CompiledCollections
+	strategy            PatchStrategy
+	hasher              *valueHasher
+	requiresReplacement []JsonPatchOperation
+}
+
+// object returns the patch for the members of b that differ from a.
+func (d *smpDiffer) object(a, b map[string]any, p string) map[string]any {
+	patch := map[string]any{}
+	for _, key := range slices.Sorted(maps.Keys(b)) {
+		bv := b[key]
+		memberPath := makePath(p, key)
+		av, found := a[key]
+		if isTombstone(bv) {
+			if found {
+				patch[key] = nil // deletes the member
+			}
+			continue
+		}
+		if d.collections.ignores(memberPath, av, found, bv, true) {
+			continue
+		}
+		ignoreArrayOrder := !d.collections.isArray(memberPath) && !d.collections.isAtomic(memberPath)
+		if found && matchesValue(av, bv, memberPath, ignoreArrayOrder, d.hasher) {
+			continue
+		}
+		if d.collections.isImmutable(memberPath) {
+			op := "replace"
+			if !found {
+				op = "add"
+			}
+			d.requiresReplacement = append(d.requiresReplacement, NewPatch(op, memberPath, bv))
+			continue
+		}
+		d.value(patch, key, av, bv, memberPath)
+	}
+	return patch
+}
+
+// value adds the patch for the member `key` changing from av to bv to patch.
+func (d *smpDiffer) value(patch map[string]any, key string, av, bv any, p string) {
+	if av == nil || d.collections.isAtomic(p) {
+		patch[key] = bv
+		return
+	}
+	switch at := av.(type) {
+	case map[string]any:
+		bt, ok := bv.(map[string]any)
+		if !ok {
+			patch[key] = bv
+			return
+		}
+		if member := d.object(at, bt, p); len(member) > 0 {
+			patch[key] = member
+		}
+	case []any:
+		bt, ok := bv.([]any)
+		if !ok {
+			patch[key] = bv
+			return
+		}
+		d.list(patch, key, at, bt, p)
+	default:
+		patch[key] = bv
+	}
+}
+
+func (d *smpDiffer) list(patch map[string]any, key string, a, b []any, p string) {
+	if !d.collections.isArray(p) {
+		b = d.collections.withoutIgnoredElements(a, b, p, d.hasher)
+	}
+	switch {
+	case d.collections.isEntitySet(p):
+		mergeKey, _ := d.collections.entitySetKey(p)
+		if len(mergeKey.Fields()) > 1 || !allObjects(a) || !allObjects(b) {
+			patch[key] = append(slices.Clone(b), map[string]any{smpPatchDirective: "replace"})
+			return
+		}
+		d.mergeList(patch, key, a, b, p, mergeKey.Fields()[0])
+	case !d.collections.isArray(p) && allPrimitives(a) && allPrimitives(b):
+		d.primitiveList(patch, key, a, b, p)
+	case d.strategy == PatchStrategyExactMatch:
+		patch[key] = b
+	default:
+		// Without a merge key the list can only be replaced, keep what is in a and add what is missing.
+		merged := slices.Clone(a)
+		processArray(b, a, p, func(_ int, value any) {
+			merged = append(merged, value)
+		}, PatchStrategyExactMatch, d.hasher)
+		if len(merged) > len(a) {
+			patch[key] = merged
+		}
+	}
+}
+
+// mergeList adds the patch for an entity set, using the field `mergeKey` of its elements as the merge key.
+func (d *smpDiffer) mergeList(patch map[string]any, key string, a, b []any, p string, mergeKey string) {
+	b, tombstones := splitTombstones(b)
+	indexes := make(map[string]int, len(a))
+	for i, v := range a {
+		if identity, err := entityIdentity(v, Key(mergeKey)); err == nil {
+			indexes[identity] = i
+		}
+	}
+
+	elements := []any{}
+	order := make([]any, 0, len(b))
+	matched := make(map[int]struct{}, len(b))
+	for _, v := range b {
+		element := v.(map[string]any)
+		order = append(order, map[string]any{mergeKey: element[mergeKey]})
+		identity, err := entityIdentity(v, Key(mergeKey))
+		i, found := indexes[identity]
+		if err != nil || !found {
+			elements = append(elements, element)
+			continue
+		}
+		matched[i] = struct{}{}
+		if changes := d.object(a[i].(map[string]any), element, makePath(p, i)); len(changes) > 0 {
+			changes[mergeKey] = element[mergeKey]
+			elements = append(elements, changes)
+		}
+	}
+
+	deleted := deletedEntities(a, tombstones, p, d.collections)
+	if d.strategy == PatchStrategyExactMatch {
+		deleted = deleted[:0]
+		for i := range a {
+			if _, ok := matched[i]; !ok {
+				deleted = append(deleted, i)
+			}
+		}
+	}
+	for _, i := range deleted {
+		elements = append(elements, map[string]any{mergeKey: a[i].(map[string]any)[mergeKey], smpPatchDirective: "delete"})
+	}
+	if len(elements) > 0 {
+		patch[key] = elements
+	}
+	if d.strategy == PatchStrategyExactMatch && len(elements) > 0 {
+		patch[smpSetElementOrderPrefix+key] = order
+	}
+}
+
+// primitiveList adds the patch for a set of primitive values.
+func (d *smpDiffer) primitiveList(patch map[string]any, key string, a, b []any, p string) {
+	var added, removed []any
+	processSet(b, a, p, func(_ int, value any) { added = append(added, value) }, d.hasher)
+	if d.strategy == PatchStrategyExactMatch {
+		processSet(a, b, p, func(_ int, value any) { removed = append(removed, value) }, d.hasher)
+	}
+
+	if len(added) > 0 {
+		patch[key] = added
+	}
+	if len(removed) > 0 {
+		patch[smpDeleteFromPrimitiveList+key] = removed
+	}
+	if d.strategy == PatchStrategyExactMatch && (len(added) > 0 || len(removed) > 0) {
+		patch[smpSetElementOrderPrefix+key] = b
+	}
+}
+
+func allObjects(values []any) bool {
+	for _, v := range values {
+		if _, ok := v.(map[string]any); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func allPrimitives(values []any) bool {
+	for _, v := range values {
+		switch v.(type) {
+		case map[string]any, []any:
+			return false
+		}
+	}
+	return true
+}
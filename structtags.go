@@ -0,0 +1,189 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// StructTag is the struct tag CollectionsFromStruct reads. Its values are:
+//
+//   - `jsonpatch:"set"`: the slice is a set, the default for slices that are not tagged
+//   - `jsonpatch:"entityset,key=id"`: the slice is an entity set keyed on the Json field `id` of its elements
+//   - `jsonpatch:"array"`: the slice keeps its order
+//   - `jsonpatch:"ignore"`: the field is one of the IgnoredFields
+const StructTag = "jsonpatch"
+
+// CollectionsFromStruct derives Collections from the `jsonpatch` tags of a struct type, so the diff rules live next to
+// the type definition. `v` is a value of the type or a pointer to one, e.g. `CollectionsFromStruct(Bucket{})`.
+//
+// Paths are built from the `json` tags, the same way encoding/json names the fields. Embedded structs are inlined,
+// nested structs and the elements of slices are followed, maps are not since their keys are not known up front.
+func CollectionsFromStruct(v any) (Collections, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return Collections{}, fmt.Errorf("expected a struct, got %T", v)
+	}
+	w := &structWalker{entitySets: EntitySets{}, arrays: []Path{}, ignoredFields: []Path{}}
+	if err := w.walkStruct(t, "$"); err != nil {
+		return Collections{}, err
+	}
+	collections := Collections{EntitySets: w.entitySets, Arrays: w.arrays, IgnoredFields: w.ignoredFields}
+	if _, err := collections.Compile(); err != nil {
+		return Collections{}, fmt.Errorf("%s tags describe invalid collections: %w", StructTag, err)
+	}
+	return collections, nil
+}
+
+type structWalker struct {
+	types         []reflect.Type // the struct types being walked, to stop at recursive types
+	entitySets    EntitySets
+	arrays        []Path
+	ignoredFields []Path
+}
+
+func (w *structWalker) walkStruct(t reflect.Type, path string) error {
+	if slices.Contains(w.types, t) {
+		return nil
+	}
+	w.types = append(w.types, t)
+	defer func() { w.types = w.types[:len(w.types)-1] }()
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, inline, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+		if inline {
+			if err := w.walkStruct(indirect(field.Type), path); err != nil {
+				return err
+			}
+			continue
+		}
+		fieldPath := path + "." + name
+		if err := w.walkField(field, fieldPath); err != nil {
+			return fmt.Errorf("%s.%s: %w", t.Name(), field.Name, err)
+		}
+	}
+	return nil
+}
+
+func (w *structWalker) walkField(field reflect.StructField, path string) error {
+	kind, key, err := parseStructTag(field.Tag.Get(StructTag))
+	if err != nil {
+		return err
+	}
+	t := indirect(field.Type)
+	isSlice := (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || t.Kind() == reflect.Array
+
+	switch kind {
+	case "ignore":
+		w.ignoredFields = append(w.ignoredFields, Path(path))
+		return nil
+	case "set", "array", "entityset":
+		if !isSlice {
+			return fmt.Errorf("%s:%q needs a slice or array, got %s", StructTag, kind, field.Type)
+		}
+	}
+	switch kind {
+	case "array":
+		w.arrays = append(w.arrays, Path(path))
+	case "entityset":
+		element := indirect(t.Elem())
+		if element.Kind() != reflect.Struct {
+			return fmt.Errorf("%s:%q needs a slice of structs, got %s", StructTag, kind, field.Type)
+		}
+		if !hasJsonField(element, key) {
+			return fmt.Errorf("entity set key %q is not a field of %s", key, element)
+		}
+		w.entitySets[Path(path)] = Key(key)
+	}
+	return w.walkType(t, path)
+}
+
+// walkType follows nested structs and the elements of slices, looking for more tags.
+func (w *structWalker) walkType(t reflect.Type, path string) error {
+	t = indirect(t)
+	switch t.Kind() {
+	case reflect.Struct:
+		return w.walkStruct(t, path)
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return nil // []byte is encoded as a string
+		}
+		return w.walkType(t.Elem(), path+"[*]")
+	}
+	return nil
+}
+
+// parseStructTag splits a `jsonpatch` tag into its kind and, for entity sets, its key.
+func parseStructTag(tag string) (kind, key string, err error) {
+	if tag == "" {
+		return "", "", nil
+	}
+	kind, options, _ := strings.Cut(tag, ",")
+	switch kind {
+	case "set", "array", "ignore":
+		if options != "" {
+			return "", "", fmt.Errorf("%s:%q does not take options", StructTag, kind)
+		}
+	case "entityset":
+		key, ok := strings.CutPrefix(options, "key=")
+		if !ok || key == "" || strings.Contains(key, ",") {
+			return "", "", fmt.Errorf("%s:%q needs a single key=<field> option", StructTag, tag)
+		}
+		return kind, key, nil
+	default:
+		return "", "", fmt.Errorf("unknown %s tag %q", StructTag, tag)
+	}
+	return kind, "", nil
+}
+
+// jsonFieldName returns the name encoding/json uses for a field, whether an embedded struct is inlined, and false if
+// the field is not encoded at all.
+func jsonFieldName(field reflect.StructField) (name string, inline bool, ok bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	name, _, _ = strings.Cut(tag, ",")
+	if field.Anonymous && name == "" && indirect(field.Type).Kind() == reflect.Struct {
+		return "", true, true
+	}
+	if !field.IsExported() {
+		return "", false, false
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, false, true
+}
+
+func hasJsonField(t reflect.Type, name string) bool {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		fieldName, inline, ok := jsonFieldName(field)
+		switch {
+		case !ok:
+		case inline:
+			if hasJsonField(indirect(field.Type), name) {
+				return true
+			}
+		case fieldName == name:
+			return true
+		}
+	}
+	return false
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,126 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DeleteDirective marks a value in the desired document as one that must be gone, whatever the strategy. A member
+// `{"field": {"$delete": true}}` removes `field`, an element `{"k": 2, "$delete": true}` of an entity set removes the
+// element with the key 2. Neither is ever added. Elements of sets and Arrays have no key to say which element to
+// remove, a tombstone among them is an error.
+const DeleteDirective = "$delete"
+
+func errTombstoneElement(p string) error {
+	return fmt.Errorf("%s at %s: only the elements of entity sets can be deleted", DeleteDirective, p)
+}
+
+func isTombstone(v any) bool {
+	t, ok := v.(map[string]any)
+	if !ok {
+		return false
+	}
+	deleted, _ := t[DeleteDirective].(bool)
+	return deleted
+}
+
+// splitTombstones separates the tombstones from the other values.
+func splitTombstones(values []any) (kept, tombstones []any) {
+	if !slices.ContainsFunc(values, isTombstone) {
+		return values, nil
+	}
+	kept = make([]any, 0, len(values))
+	for _, v := range values {
+		if isTombstone(v) {
+			tombstones = append(tombstones, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	return kept, tombstones
+}
+
+// deletedEntities returns the indexes of the elements of the entity set av at p that match one of the tombstones, in
+// ascending order.
+func deletedEntities(av, tombstones []any, p string, collections *CompiledCollections) []int {
+	key, ok := collections.entitySetKey(p)
+	if !ok {
+		return nil
+	}
+	deleted := make(map[string]struct{}, len(tombstones))
+	for _, tombstone := range tombstones {
+		if identity, err := entityIdentity(tombstone, key); err == nil {
+			deleted[identity] = struct{}{}
+		}
+	}
+	var indexes []int
+	for i, v := range av {
+		if identity, err := entityIdentity(v, key); err == nil {
+			if _, ok := deleted[identity]; ok {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	return indexes
+}
+
+func containsTombstone(v any) bool {
+	switch t := v.(type) {
+	case map[string]any:
+		if isTombstone(t) {
+			return true
+		}
+		for _, member := range t {
+			if containsTombstone(member) {
+				return true
+			}
+		}
+	case []any:
+		for _, element := range t {
+			if containsTombstone(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutTombstones returns a copy of v without the tombstones in it, v itself if it has none.
+func withoutTombstones(v any) any {
+	if !containsTombstone(v) {
+		return v
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(t))
+		for key, member := range t {
+			if !isTombstone(member) {
+				copied[key] = withoutTombstones(member)
+			}
+		}
+		return copied
+	case []any:
+		copied := make([]any, 0, len(t))
+		for _, element := range t {
+			if !isTombstone(element) {
+				copied = append(copied, withoutTombstones(element))
+			}
+		}
+		return copied
+	}
+	return v
+}
+
+// withoutTombstonesInValues removes the tombstones from the values of the operations, they have no meaning in a
+// value that is added as a whole. Operations that would add a tombstone itself are dropped.
+func withoutTombstonesInValues(patch []JsonPatchOperation) []JsonPatchOperation {
+	filtered := patch[:0]
+	for _, op := range patch {
+		if isTombstone(op.Value) {
+			continue
+		}
+		op.Value = withoutTombstones(op.Value)
+		filtered = append(filtered, op)
+	}
+	return filtered
+}